@@ -20,28 +20,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-
 	"strconv"
+	"sync"
 
 	"github.com/gorilla/mux"
-	"github.com/olivere/elastic"
+	"maunium.net/go/lindeb/archive"
+	"maunium.net/go/lindeb/crawler"
 	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/search"
 )
 
 // API contains objects needed by the API handlers to function.
 type API struct {
-	DB           *db.DB
-	Elastic      *elastic.Client
-	elasticQueue chan func()
-	stop         chan bool
+	DB          *db.DB
+	SearchIndex search.Index
+	Admins      []string
+	Queue       QueueConfig
+	HealthCheck HealthCheckConfig
+	Archive     *archive.Store  // nil if the archive is disabled
+	Thumbnails  *ThumbnailCache // nil if thumbnails are disabled
+	Fetcher     *crawler.Fetcher
+	jobSignal   chan struct{}
+	stop        chan bool
+
+	reindexStatus ReindexStatus
+	reindexLock   sync.Mutex
 }
 
-func Create(db *db.DB, elastic *elastic.Client) *API {
+func Create(db *db.DB, index search.Index, queue QueueConfig, fetcher *crawler.Fetcher) *API {
 	return &API{
-		DB:           db,
-		Elastic:      elastic,
-		elasticQueue: make(chan func(), 4096),
-		stop:         make(chan bool, 1),
+		DB:          db,
+		SearchIndex: index,
+		Queue:       queue.withDefaults(),
+		Fetcher:     fetcher,
+		jobSignal:   make(chan struct{}, 1),
+		stop:        make(chan bool, 1),
 	}
 }
 
@@ -49,18 +62,6 @@ func (api *API) Stop() {
 	api.stop <- true
 }
 
-func (api *API) StartElasticQueue() {
-	for {
-		select {
-		case elasticCall := <-api.elasticQueue:
-			elasticCall()
-		case <-api.stop:
-			api.stop <- true
-			return
-		}
-	}
-}
-
 // AddHandler registers all the API paths.
 func (api *API) AddHandler(router *mux.Router) {
 	auth := router.PathPrefix("/auth").Methods(http.MethodPost).Subrouter()
@@ -72,8 +73,29 @@ func (api *API) AddHandler(router *mux.Router) {
 	router.Handle("/link/save", api.AuthMiddleware(http.HandlerFunc(api.SaveLink))).Methods(http.MethodPost, http.MethodGet)
 	router.Handle("/link/{id:[0-9]+}", api.AuthMiddleware(api.LinkMiddleware(http.HandlerFunc(api.AccessLink)))).
 		Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	router.Handle("/link/{id:[0-9]+}/status", api.AuthMiddleware(api.LinkMiddleware(http.HandlerFunc(api.GetLinkIndexStatus)))).
+		Methods(http.MethodGet)
+	router.Handle("/link/{id:[0-9]+}/related", api.AuthMiddleware(api.LinkMiddleware(http.HandlerFunc(api.GetRelatedLinks)))).
+		Methods(http.MethodGet)
+	router.Handle("/link/{id:[0-9]+}/archive", api.AuthMiddleware(api.LinkMiddleware(http.HandlerFunc(api.GetLinkArchive)))).
+		Methods(http.MethodGet)
+	router.Handle("/link/{id:[0-9]+}/favicon", api.AuthMiddleware(api.LinkMiddleware(http.HandlerFunc(api.GetLinkFavicon)))).
+		Methods(http.MethodGet)
+	router.Handle("/link/{id:[0-9]+}/thumbnail", api.AuthMiddleware(api.LinkMiddleware(http.HandlerFunc(api.GetLinkThumbnail)))).
+		Methods(http.MethodGet)
 	router.Handle("/links", api.AuthMiddleware(http.HandlerFunc(api.BrowseLinks))).Methods(http.MethodGet)
 	router.Handle("/links/import", api.AuthMiddleware(http.HandlerFunc(api.ImportLinks))).Methods(http.MethodPost)
+	router.Handle("/links/duplicates", api.AuthMiddleware(http.HandlerFunc(api.AccessDuplicates))).
+		Methods(http.MethodGet, http.MethodPost)
+	router.Handle("/links/health", api.AuthMiddleware(http.HandlerFunc(api.GetHealthReport))).Methods(http.MethodGet)
+
+	router.Handle("/searches", api.AuthMiddleware(http.HandlerFunc(api.ListSavedSearches))).Methods(http.MethodGet)
+	router.Handle("/searches", api.AuthMiddleware(http.HandlerFunc(api.AddSavedSearch))).Methods(http.MethodPost)
+	router.Handle("/searches/{id:[0-9]+}", api.AuthMiddleware(api.SavedSearchMiddleware(http.HandlerFunc(api.AccessSavedSearch)))).
+		Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	router.Handle("/searches/{id:[0-9]+}/links", api.AuthMiddleware(api.SavedSearchMiddleware(http.HandlerFunc(api.GetSavedSearchLinks)))).
+		Methods(http.MethodGet)
+	router.Handle("/suggest", api.AuthMiddleware(http.HandlerFunc(api.Suggest))).Methods(http.MethodGet)
 
 	router.Handle("/tag/add", api.AuthMiddleware(http.HandlerFunc(api.AddTag))).Methods(http.MethodPost)
 	router.Handle("/tag/{id:[0-9]+}", api.AuthMiddleware(api.TagMiddleware(http.HandlerFunc(api.AccessTag)))).
@@ -83,6 +105,16 @@ func (api *API) AddHandler(router *mux.Router) {
 	router.Handle("/settings", api.AuthMiddleware(http.HandlerFunc(api.GetSettings))).Methods(http.MethodGet)
 	router.Handle("/setting/{key}", api.AuthMiddleware(http.HandlerFunc(api.AccessSetting))).
 		Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Handle("/reindex", api.AuthMiddleware(api.AdminMiddleware(http.HandlerFunc(api.GetReindexStatus)))).
+		Methods(http.MethodGet)
+	admin.Handle("/reindex", api.AuthMiddleware(api.AdminMiddleware(http.HandlerFunc(api.StartReindex)))).
+		Methods(http.MethodPost)
+	admin.Handle("/jobs", api.AuthMiddleware(api.AdminMiddleware(http.HandlerFunc(api.ListJobs)))).
+		Methods(http.MethodGet)
+	admin.Handle("/job/{id:[0-9]+}/retry", api.AuthMiddleware(api.AdminMiddleware(http.HandlerFunc(api.RetryJob)))).
+		Methods(http.MethodPost)
 }
 
 func internalError(w http.ResponseWriter, message string, args ...interface{}) {
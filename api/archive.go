@@ -0,0 +1,78 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"maunium.net/go/lindeb/crawler"
+	"maunium.net/go/lindeb/db"
+)
+
+// archiveContentSecurityPolicy only allows the inlined resources of snapshots and sandboxes them, so that archived
+// pages can't run scripts or send requests anywhere, even if the snapshot cleaning missed something.
+const archiveContentSecurityPolicy = "default-src 'none'; img-src data:; style-src 'unsafe-inline' data:; " +
+	"font-src data:; media-src data:; sandbox"
+
+// archivePage stores a snapshot of the crawled page in the archive. If taking the snapshot fails, the previous
+// snapshot of the link is kept.
+func (api *API) archivePage(link *db.Link, page *crawler.Page) {
+	if api.Archive == nil {
+		return
+	}
+	snapshot, err := api.Fetcher.Snapshot(page, api.Archive.Limits)
+	if err != nil {
+		fmt.Printf("Failed to take snapshot of %s: %v\n", link.URL, err)
+		return
+	}
+	hash, err := api.Archive.Put(snapshot.Body)
+	if err != nil {
+		fmt.Printf("Failed to store snapshot of %s: %v\n", link.URL, err)
+		return
+	}
+	link.ArchiveHash = hash
+	link.ArchiveType = snapshot.ContentType
+	link.ArchivedAt = time.Now().Unix()
+}
+
+// GetLinkArchive is the handler for GET /api/link/<id>/archive
+func (api *API) GetLinkArchive(w http.ResponseWriter, r *http.Request) {
+	link := api.GetLinkFromContext(r)
+	if api.Archive == nil || len(link.ArchiveHash) == 0 {
+		http.Error(w, "Link has not been archived.", http.StatusNotFound)
+		return
+	}
+	file, err := api.Archive.Open(link.ArchiveHash)
+	if os.IsNotExist(err) {
+		http.Error(w, "Link has not been archived.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		internalError(w, "Failed to open archive of link %d: %v", link.ID, err)
+		return
+	}
+	defer file.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", link.ArchiveType)
+	header.Set("Content-Security-Policy", archiveContentSecurityPolicy)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Referrer-Policy", "no-referrer")
+	http.ServeContent(w, r, "", time.Unix(link.ArchivedAt, 0), file)
+}
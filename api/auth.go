@@ -188,6 +188,27 @@ func (api *API) AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// IsAdmin checks whether or not the given user is listed as an administrator in the config.
+func (api *API) IsAdmin(user *db.User) bool {
+	for _, admin := range api.Admins {
+		if admin == user.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminMiddleware checks that the user who sent the request is an administrator. Must be used after AuthMiddleware.
+func (api *API) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.IsAdmin(api.GetUserFromContext(r)) {
+			http.Error(w, "You are not an administrator.", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // GetUserFromContext gets the database user object from the context of the given request.
 //
 // Calling this function with a request that did not go through the auth check middleware is strictly forbidden and
@@ -17,19 +17,64 @@
 package api
 
 import (
-	"context"
 	"fmt"
 	"net/http"
-	"reflect"
 	"strconv"
+	"time"
 
-	"github.com/olivere/elastic"
 	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/search"
 )
 
 type listResponse struct {
-	Links      []apiLink `json:"links"`
-	TotalCount int       `json:"totalCount"`
+	Links      []apiLink  `json:"links"`
+	TotalCount int        `json:"totalCount"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+	Facets     *apiFacets `json:"facets,omitempty"`
+}
+
+type apiFacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type apiFacets struct {
+	Tags    []apiFacetCount `json:"tags"`
+	Domains []apiFacetCount `json:"domains"`
+	Months  []apiFacetCount `json:"months"`
+}
+
+func dbToAPIFacetCounts(counts []db.FacetCount) []apiFacetCount {
+	apiCounts := make([]apiFacetCount, len(counts))
+	for index, count := range counts {
+		apiCounts[index] = apiFacetCount{count.Value, count.Count}
+	}
+	return apiCounts
+}
+
+func searchToAPIFacetCounts(counts []search.FacetCount) []apiFacetCount {
+	apiCounts := make([]apiFacetCount, len(counts))
+	for index, count := range counts {
+		apiCounts[index] = apiFacetCount{count.Value, count.Count}
+	}
+	return apiCounts
+}
+
+// getFacetSize gets the number of top tags and domains to return from the query parameters in the given request. If
+// facets were not requested, zero is returned.
+//
+// If an error occurs, the second return value (ok) is set to false and a HTTP error is written to the given response
+// writer.
+func getFacetSize(w http.ResponseWriter, r *http.Request) (size int, ok bool) {
+	if len(r.URL.Query().Get("facets")) == 0 {
+		return 0, true
+	}
+	size, ok = getQueryInt(w, r, "facetsize", 10)
+	if ok && (size <= 0 || size > 100) {
+		http.Error(w, "Facet size must be between 1 and 100.", http.StatusBadRequest)
+		return 0, false
+	}
+	return
 }
 
 // getQueryInt gets an integer value from the query parameter with the given name.
@@ -53,29 +98,28 @@ func getQueryInt(w http.ResponseWriter, r *http.Request, name string, defVal int
 	return val, true
 }
 
-// filterLinks filters a list of links based on the query parameters in the given request.
-//
-// If an error occurs, the second return value (totalCount) is set to -1 and a HTTP error is written to the given
-// response writer.
-func filterLinks(r *http.Request, links []*db.Link) (filtered []apiLink) {
-	tags := r.URL.Query()["tag"]
-	exclusiveTags := len(r.URL.Query().Get("exclusivetags")) > 0
-	domains := r.URL.Query()["domain"]
-
-	for _, link := range links {
-		if link.Matches(domains, tags, exclusiveTags) {
-			filtered = append(filtered, dbToAPILink(link))
-		}
+func getQueryBool(w http.ResponseWriter, r *http.Request, name string) (val bool, ok bool) {
+	str := r.URL.Query().Get(name)
+	if len(str) == 0 {
+		return false, true
 	}
 
-	if filtered == nil {
-		// Return an empty array instead of null.
-		filtered = []apiLink{}
+	var err error
+	val, err = strconv.ParseBool(str)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Non-boolean value for query parameter %s: %s", name, str), http.StatusBadRequest)
+		return false, false
 	}
-	return
+
+	return val, true
 }
 
-func paginate(w http.ResponseWriter, r *http.Request, links []apiLink) (paginated []apiLink, ok bool) {
+// getPagination gets the page and page size from the query parameters in the given request and converts them into an
+// offset and a limit. If pagination is not enabled, the limit is zero.
+//
+// If an error occurs, the third return value (ok) is set to false and a HTTP error is written to the given response
+// writer.
+func getPagination(w http.ResponseWriter, r *http.Request) (from, size int, ok bool) {
 	var page, pageSize int
 	if page, ok = getQueryInt(w, r, "page", 0); !ok {
 		return
@@ -85,118 +129,237 @@ func paginate(w http.ResponseWriter, r *http.Request, links []apiLink) (paginate
 	}
 
 	if page > 0 && pageSize > 0 {
-		from := (page - 1) * pageSize
-		to := page * pageSize
-		if from < len(links) {
-			if to < len(links) {
-				// From and to are within link list, get the section ruled out by the two.
-				paginated = links[from:to]
-			} else {
-				// To is outside the list, get everything after from.
-				paginated = links[from:]
-			}
-		} else {
-			// From is outside the list -> no results.
-			paginated = []apiLink{}
-		}
-	} else {
-		paginated = links
+		from = (page - 1) * pageSize
+		size = pageSize
 	}
+	return
+}
 
-	ok = true
-
+// getSort gets the sort option from the query parameters in the given request.
+//
+// If the sort option is invalid, the second return value (ok) is set to false and a HTTP error is written to the given
+// response writer.
+func getSort(w http.ResponseWriter, r *http.Request) (order search.Sort, ok bool) {
+	order, ok = search.ParseSort(r.URL.Query().Get("sort"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown sort option: %s", order), http.StatusBadRequest)
+	}
 	return
 }
 
-func stringToInterfaceSlice(slice []string) []interface{} {
-	new := make([]interface{}, len(slice))
-	for i, v := range slice {
-		new[i] = v
+// getQueryTime gets a unix timestamp from the query parameter with the given name. The value can be a date
+// (2006-01-02), an RFC 3339 time or a unix timestamp. If endOfDay is true, dates are converted to the last second of
+// the day instead of the first.
+//
+// If the parameter is not present, zero is returned.
+// If the parameter is not a valid time, the second return value (ok) is set to false and a HTTP error is written to
+// the given response writer.
+func getQueryTime(w http.ResponseWriter, r *http.Request, name string, endOfDay bool) (val int64, ok bool) {
+	str := r.URL.Query().Get(name)
+	if len(str) == 0 {
+		return 0, true
 	}
-	return new
+	val, err := parseTime(str, endOfDay)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid time for query parameter %s: %s", name, str), http.StatusBadRequest)
+		return 0, false
+	}
+	return val, true
 }
 
-func (api *API) buildQuery(user *db.User, search string, domains []string, tags []string, exclusiveTags bool) elastic.Query {
-	query := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
-	query.Filter(elastic.NewTermQuery("owner", user.ID))
-	query.Should(elastic.NewFuzzyQuery("html", search).Boost(0.1))
-	query.Should(elastic.NewFuzzyQuery("url", search).Boost(0.3))
-	query.Should(elastic.NewMultiMatchQuery(search, "title", "description").Fuzziness("auto").Boost(1.5))
-	if len(tags) > 0 {
-		if exclusiveTags {
-			for _, tag := range tags {
-				query.Must(elastic.NewTermQuery("tags", tag))
+func parseTime(str string, endOfDay bool) (int64, error) {
+	if date, err := time.Parse("2006-01-02", str); err == nil {
+		if endOfDay {
+			date = date.Add(24*time.Hour - time.Second)
+		}
+		return date.Unix(), nil
+	} else if parsed, err := time.Parse(time.RFC3339, str); err == nil {
+		return parsed.Unix(), nil
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+// getLinkFilter gets the filters and pagination for listing links from the query parameters in the given request.
+//
+// If an error occurs, the second return value (ok) is set to false and a HTTP error is written to the given response
+// writer.
+func getLinkFilter(w http.ResponseWriter, r *http.Request, order search.Sort) (filter db.LinkFilter, ok bool) {
+	filter.Tags = r.URL.Query()["tag"]
+	filter.ExclusiveTags = len(r.URL.Query().Get("exclusivetags")) > 0
+	filter.Domains = r.URL.Query()["domain"]
+	if order == search.SortRelevance {
+		filter.Order = db.OrderNewest
+	} else {
+		filter.Order = db.LinkOrder(order)
+	}
+	filter.Health = db.LinkHealth(r.URL.Query().Get("health"))
+	if len(filter.Health) > 0 && filter.Health != db.HealthBroken && filter.Health != db.HealthRedirected {
+		http.Error(w, fmt.Sprintf("Unknown health filter: %s", filter.Health), http.StatusBadRequest)
+		return filter, false
+	}
+
+	if filter.Since, ok = getQueryTime(w, r, "since", false); !ok {
+		return
+	}
+	if filter.Until, ok = getQueryTime(w, r, "until", true); !ok {
+		return
+	}
+	if filter.Offset, filter.Limit, ok = getPagination(w, r); !ok {
+		return
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); len(cursor) > 0 {
+		var err error
+		filter.After, err = db.ParseLinkCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor.", http.StatusBadRequest)
+			return filter, false
+		}
+		if filter.Limit <= 0 {
+			// Cursors replace page numbers, so only the page size is needed.
+			if filter.Limit, ok = getQueryInt(w, r, "pagesize", 10); !ok {
+				return
+			} else if filter.Limit <= 0 {
+				filter.Limit = 10
 			}
-		} else {
-			query.Must(elastic.NewTermsQuery("tags", stringToInterfaceSlice(tags)...))
 		}
 	}
-	if len(domains) > 0 {
-		query.Must(elastic.NewTermsQuery("domain", stringToInterfaceSlice(domains)))
+	return filter, true
+}
+
+// applySearchQuery adds the filters of a parsed search query to the given link filter.
+func applySearchQuery(filter *db.LinkFilter, query search.Query) {
+	// The tag: terms are required in addition to the tag parameters, which keep their own match mode.
+	filter.RequiredTags = append(filter.RequiredTags, query.RequiredTags...)
+	filter.ExcludedTags = append(filter.ExcludedTags, query.ExcludedTags...)
+	filter.Domains = append(filter.Domains, query.Domains...)
+	filter.ExcludedDomains = append(filter.ExcludedDomains, query.ExcludedDomains...)
+	filter.Title = append(filter.Title, query.Title...)
+	if query.Read != nil {
+		filter.Read = query.Read
+	}
+	if query.Since > filter.Since {
+		filter.Since = query.Since
+	}
+	if query.Until != 0 && (filter.Until == 0 || query.Until < filter.Until) {
+		filter.Until = query.Until
 	}
-	return query
 }
 
-// searchLinks searches Elasticsearch with the given query.
+// searchLinks searches the search index with the given query.
 //
 // If an error occurs, the second return value (ok) is set to false and a HTTP error is written to the given response
 // writer.
-func (api *API) searchLinks(w http.ResponseWriter, user *db.User, query elastic.Query) (links []apiLink, ok bool) {
-	ok = false
-
-	results, err := api.Elastic.Search().
-		Index(ElasticIndex).
-		Type(ElasticType).
-		Routing(user.IDString()).
-		Query(query).
-		Do(context.Background())
+func (api *API) searchLinks(w http.ResponseWriter, query search.Query) (resp listResponse, ok bool) {
+	results, err := api.SearchIndex.Query(query)
 	if err != nil {
-		internalError(w, "Elasticsearch error while searching #%d's links: %v", user.ID, err)
-		return
+		internalError(w, "Search index error while searching #%d's links: %v", query.Owner, err)
+		return resp, false
 	}
-	var link apiLink
-	for _, item := range results.Each(reflect.TypeOf(link)) {
-		links = append(links, item.(apiLink))
+	resp.Links = make([]apiLink, len(results.Documents))
+	for index, doc := range results.Documents {
+		resp.Links[index] = documentToAPILink(doc)
 	}
-	ok = true
-	return
+	resp.TotalCount = results.TotalHits
+	if results.Facets != nil {
+		resp.Facets = &apiFacets{
+			Tags:    searchToAPIFacetCounts(results.Facets.Tags),
+			Domains: searchToAPIFacetCounts(results.Facets.Domains),
+			Months:  searchToAPIFacetCounts(results.Facets.Months),
+		}
+	}
+	return resp, true
 }
 
 // BrowseLinks is the handler for GET /api/links
 func (api *API) BrowseLinks(w http.ResponseWriter, r *http.Request) {
 	user := api.GetUserFromContext(r)
 
-	var links []apiLink
-	searchQuery := r.URL.Query().Get("search")
-	if len(searchQuery) == 0 {
-		dbLinks, err := user.GetLinks()
-		if err != nil {
+	order, ok := getSort(w, r)
+	if !ok {
+		return
+	}
+	query, err := search.ParseQuery(r.URL.Query().Get("search"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid search query: %v", err), http.StatusBadRequest)
+		return
+	}
+	filter, ok := getLinkFilter(w, r, order)
+	if !ok {
+		return
+	}
+	applySearchQuery(&filter, query)
+	facetSize, ok := getFacetSize(w, r)
+	if !ok {
+		return
+	} else if query.HasText() && len(filter.Health) > 0 {
+		// The health check results are not stored in the search index.
+		http.Error(w, "The health filter can't be combined with a text search.", http.StatusBadRequest)
+		return
+	}
+
+	var resp listResponse
+	if !query.HasText() {
+		// Queries without free text are just filters, which the database can handle without the search index.
+		dbLinks, err := user.QueryLinks(filter)
+		if err == db.ErrInvalidCursor {
+			http.Error(w, "Invalid cursor.", http.StatusBadRequest)
+			return
+		} else if err != nil {
 			internalError(w, "Failed to list links of %d: %v", user.ID, err)
 			return
 		}
+		resp.TotalCount, err = user.CountLinks(filter)
+		if err != nil {
+			internalError(w, "Failed to count links of %d: %v", user.ID, err)
+			return
+		}
+		if facetSize > 0 {
+			facets, err := user.GetLinkFacets(filter, facetSize)
+			if err != nil {
+				internalError(w, "Failed to get facets of %d's links: %v", user.ID, err)
+				return
+			}
+			resp.Facets = &apiFacets{
+				Tags:    dbToAPIFacetCounts(facets.Tags),
+				Domains: dbToAPIFacetCounts(facets.Domains),
+				Months:  dbToAPIFacetCounts(facets.Months),
+			}
+		}
 
-		links = filterLinks(r, dbLinks)
+		resp.Links = make([]apiLink, len(dbLinks))
+		for index, link := range dbLinks {
+			resp.Links[index] = dbToAPILink(link)
+		}
+		if filter.Limit > 0 && len(dbLinks) == filter.Limit {
+			resp.NextCursor = dbLinks[len(dbLinks)-1].Cursor(filter.Order).String()
+		}
 	} else {
-		tags := r.URL.Query()["tag"]
-		exclusiveTags := len(r.URL.Query().Get("exclusivetags")) > 0
-		domains := r.URL.Query()["domain"]
-
-		var ok bool
-		links, ok = api.searchLinks(w, user, api.buildQuery(user, searchQuery, domains, tags, exclusiveTags))
+		resp, ok = api.searchLinks(w, search.Query{
+			Owner:           user.ID,
+			Text:            query.Text,
+			Phrases:         query.Phrases,
+			ExcludedText:    query.ExcludedText,
+			Title:           filter.Title,
+			Tags:            filter.Tags,
+			ExclusiveTags:   filter.ExclusiveTags,
+			RequiredTags:    filter.RequiredTags,
+			ExcludedTags:    filter.ExcludedTags,
+			Domains:         filter.Domains,
+			ExcludedDomains: filter.ExcludedDomains,
+			Read:            filter.Read,
+			Since:           filter.Since,
+			Until:           filter.Until,
+			Sort:            order,
+			Highlight:       len(r.URL.Query().Get("highlight")) > 0,
+			FacetSize:       facetSize,
+			From:            filter.Offset,
+			Size:            filter.Limit,
+		})
 		if !ok {
 			return
 		}
 	}
 
-	totalCount := len(links)
-
-	links, ok := paginate(w, r, links)
-	if !ok {
-		return
-	}
-
-	writeJSON(w, http.StatusOK, listResponse{
-		links,
-		totalCount,
-	})
+	writeJSON(w, http.StatusOK, resp)
 }
@@ -0,0 +1,56 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/search"
+)
+
+func TestApplySearchQueryTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter db.LinkFilter
+		query  string
+		want   db.LinkFilter
+	}{
+		{"any tag params", db.LinkFilter{Tags: []string{"a", "b"}}, "tag:c",
+			db.LinkFilter{Tags: []string{"a", "b"}, RequiredTags: []string{"c"}}},
+		{"exclusive tag params", db.LinkFilter{Tags: []string{"a", "b"}, ExclusiveTags: true}, "tag:c",
+			db.LinkFilter{Tags: []string{"a", "b"}, ExclusiveTags: true, RequiredTags: []string{"c"}}},
+		{"only query tags", db.LinkFilter{}, "tag:c tag:d -tag:e",
+			db.LinkFilter{RequiredTags: []string{"c", "d"}, ExcludedTags: []string{"e"}}},
+		{"no query tags", db.LinkFilter{Tags: []string{"a", "b"}}, "text",
+			db.LinkFilter{Tags: []string{"a", "b"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := search.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter := test.filter
+			applySearchQuery(&filter, query)
+			if !reflect.DeepEqual(filter, test.want) {
+				t.Errorf("applySearchQuery(%q) = %+v, want %+v", test.query, filter, test.want)
+			}
+		})
+	}
+}
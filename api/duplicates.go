@@ -0,0 +1,248 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"maunium.net/go/lindeb/crawler"
+	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/util"
+)
+
+// DuplicateMode is what SaveLink does when the link being saved has already been saved.
+type DuplicateMode string
+
+const (
+	// DuplicateMerge means that the tags and the other fields given when saving are added to the existing link.
+	DuplicateMerge DuplicateMode = "merge"
+	// DuplicateReject means that the link is not saved and HTTP Conflict is returned.
+	DuplicateReject DuplicateMode = "reject"
+	// DuplicateAllow means that the link is saved again.
+	DuplicateAllow DuplicateMode = "allow"
+)
+
+// getDuplicateMode reads the onDuplicate query parameter. If the value is invalid, HTTP Bad Request is returned and
+// ok is false.
+func getDuplicateMode(w http.ResponseWriter, r *http.Request) (mode DuplicateMode, ok bool) {
+	mode = DuplicateMode(r.URL.Query().Get("onDuplicate"))
+	switch mode {
+	case "":
+		return DuplicateMerge, true
+	case DuplicateMerge, DuplicateReject, DuplicateAllow:
+		return mode, true
+	default:
+		http.Error(w, "Duplicate mode must be merge, reject or allow.", http.StatusBadRequest)
+		return "", false
+	}
+}
+
+// canonicalNormalizedURL gets the normalized URL of a crawled link. The canonical URL of the page is preferred, as it
+// can also be the same for addresses that the normalization doesn't recognize as equal. Some websites use their front
+// page as the canonical URL of every page, so canonical URLs without a path are only used for links without a path.
+func canonicalNormalizedURL(link *db.Link, meta *crawler.Metadata) string {
+	linkURL := util.NormalizeURL(link.URL)
+	canonical, err := url.Parse(meta.CanonicalURL)
+	if len(meta.CanonicalURL) == 0 || err != nil {
+		return linkURL
+	}
+	canonicalURL := util.NormalizeURL(canonical)
+	if canonical.Path == "" || canonical.Path == "/" {
+		if linkPath := link.URL.Path; linkPath != "" && linkPath != "/" {
+			return linkURL
+		}
+	}
+	return canonicalURL
+}
+
+// mergeSavedLink adds the fields given when saving a link again to the existing link. Non-empty titles and
+// descriptions replace the current ones, the read status is changed if it's not nil and the tags are added to the
+// current tags.
+func (api *API) mergeSavedLink(existing *db.Link, title, description string, read *bool, tags []string) error {
+	if len(title) > 0 {
+		existing.Title = title
+		existing.CustomTitle = true
+	}
+	if len(description) > 0 {
+		existing.Description = description
+		existing.CustomDescription = true
+	}
+	if read != nil {
+		existing.Read = *read
+	}
+	err := existing.UpdateFields(false)
+	if err != nil {
+		return fmt.Errorf("failed to update link %d: %v", existing.ID, err)
+	}
+	err = existing.UpdateTags(appendMissing(existing.Tags, tags))
+	if err != nil {
+		return fmt.Errorf("failed to update tags of link %d: %v", existing.ID, err)
+	}
+	api.enqueueJob(db.JobIndex, existing.Owner.ID, existing.ID, "")
+	return nil
+}
+
+// mergeLinks merges the duplicates into the kept link and deletes them. The kept link gets the tags of all the links,
+// and it's marked as read if any of them has been read. Titles and descriptions set by the user are kept, preferring
+// the ones of the kept link.
+func (api *API) mergeLinks(kept *db.Link, duplicates []*db.Link) error {
+	tags := kept.Tags
+	for _, duplicate := range duplicates {
+		tags = appendMissing(tags, duplicate.Tags)
+		kept.Read = kept.Read || duplicate.Read
+		if duplicate.CustomTitle && !kept.CustomTitle {
+			kept.Title, kept.CustomTitle = duplicate.Title, true
+		}
+		if duplicate.CustomDescription && !kept.CustomDescription {
+			kept.Description, kept.CustomDescription = duplicate.Description, true
+		}
+	}
+
+	err := kept.UpdateFields(false)
+	if err != nil {
+		return fmt.Errorf("failed to update link %d: %v", kept.ID, err)
+	}
+	err = kept.UpdateTags(tags)
+	if err != nil {
+		return fmt.Errorf("failed to update tags of link %d: %v", kept.ID, err)
+	}
+	for _, duplicate := range duplicates {
+		err = duplicate.Delete()
+		if err != nil {
+			return fmt.Errorf("failed to delete link %d: %v", duplicate.ID, err)
+		}
+		api.enqueueJob(db.JobDelete, kept.Owner.ID, duplicate.ID, "")
+	}
+	api.enqueueJob(db.JobIndex, kept.Owner.ID, kept.ID, "")
+	return nil
+}
+
+// appendMissing appends the values that are not in the list yet.
+func appendMissing(list, values []string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+type duplicateGroup struct {
+	NormalizedURL string    `json:"normalizedUrl"`
+	Links         []apiLink `json:"links"`
+}
+
+type duplicateReport struct {
+	// DuplicateCount is the number of links that would be removed by merging all the groups.
+	DuplicateCount int              `json:"duplicateCount"`
+	Groups         []duplicateGroup `json:"groups"`
+}
+
+type mergeResult struct {
+	MergedCount int       `json:"mergedCount"`
+	Links       []apiLink `json:"links"`
+}
+
+// groupDuplicates splits the duplicate links into groups by normalized URL. The links are expected to be ordered by
+// normalized URL.
+func groupDuplicates(links []*db.Link) [][]*db.Link {
+	var groups [][]*db.Link
+	for index, link := range links {
+		if index == 0 || link.NormalizedURL != links[index-1].NormalizedURL {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], link)
+	}
+	return groups
+}
+
+// AccessDuplicates is a method proxy for the handlers of /api/links/duplicates
+func (api *API) AccessDuplicates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		api.GetDuplicates(w, r)
+	case http.MethodPost:
+		api.MergeDuplicates(w, r)
+	default:
+		// Invalid methods should be prevented at the router level, so just panic if the router is misconfigured.
+		panic("Fatal: AccessDuplicates called with invalid method.")
+	}
+}
+
+// GetDuplicates is the handler for GET /api/links/duplicates
+func (api *API) GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	user := api.GetUserFromContext(r)
+	links, err := user.GetDuplicateLinks()
+	if err != nil {
+		internalError(w, "Failed to get duplicate links of %d: %v", user.ID, err)
+		return
+	}
+
+	report := duplicateReport{Groups: []duplicateGroup{}}
+	for _, group := range groupDuplicates(links) {
+		apiLinks := make([]apiLink, len(group))
+		for index, link := range group {
+			apiLinks[index] = dbToAPILink(link)
+		}
+		report.Groups = append(report.Groups, duplicateGroup{
+			NormalizedURL: group[0].NormalizedURL,
+			Links:         apiLinks,
+		})
+		report.DuplicateCount += len(group) - 1
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// MergeDuplicates is the handler for POST /api/links/duplicates
+func (api *API) MergeDuplicates(w http.ResponseWriter, r *http.Request) {
+	user := api.GetUserFromContext(r)
+	onlyURL := r.URL.Query().Get("normalizedUrl")
+	links, err := user.GetDuplicateLinks()
+	if err != nil {
+		internalError(w, "Failed to get duplicate links of %d: %v", user.ID, err)
+		return
+	}
+
+	result := mergeResult{Links: []apiLink{}}
+	for _, group := range groupDuplicates(links) {
+		if len(onlyURL) > 0 && group[0].NormalizedURL != onlyURL {
+			continue
+		}
+		// The oldest link is kept.
+		err = api.mergeLinks(group[0], group[1:])
+		if err != nil {
+			internalError(w, "Failed to merge duplicate links of %d: %v", user.ID, err)
+			return
+		}
+		result.MergedCount += len(group) - 1
+		result.Links = append(result.Links, dbToAPILink(group[0]))
+	}
+	if len(onlyURL) > 0 && len(result.Links) == 0 {
+		http.Error(w, "No duplicates found with the given normalized URL.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
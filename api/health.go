@@ -0,0 +1,195 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"maunium.net/go/lindeb/crawler"
+	"maunium.net/go/lindeb/db"
+)
+
+// HealthCheckConfig contains the settings of the periodic link health checker.
+type HealthCheckConfig struct {
+	// Enabled determines whether links are checked periodically. Links are checked when they're crawled either way.
+	Enabled bool `yaml:"enabled"`
+	// Interval is the number of hours between checks of each link.
+	Interval int `yaml:"interval"`
+	// BatchSize is the maximum number of links of a single user that are checked in one round.
+	BatchSize int `yaml:"batch_size"`
+}
+
+// DefaultHealthCheckConfig contains the health check settings used for fields that are not set in the config.
+var DefaultHealthCheckConfig = HealthCheckConfig{
+	Interval:  7 * 24,
+	BatchSize: 50,
+}
+
+// withDefaults returns a copy of the config where unset fields have their default values.
+func (conf HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if conf.Interval <= 0 {
+		conf.Interval = DefaultHealthCheckConfig.Interval
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultHealthCheckConfig.BatchSize
+	}
+	return conf
+}
+
+const healthCheckPollInterval = time.Minute
+
+// StartHealthChecker starts checking the links of all users periodically if it's enabled in the config.
+func (api *API) StartHealthChecker() {
+	if api.HealthCheck.Enabled {
+		go api.healthChecker(api.HealthCheck.withDefaults())
+	}
+}
+
+func (api *API) healthChecker(conf HealthCheckConfig) {
+	ticker := time.NewTicker(healthCheckPollInterval)
+	defer ticker.Stop()
+	for {
+		api.checkDueLinks(conf)
+		select {
+		case <-ticker.C:
+		case <-api.stop:
+			api.stop <- true
+			return
+		}
+	}
+}
+
+// checkDueLinks checks a batch of the links of each user that haven't been checked within the interval.
+func (api *API) checkDueLinks(conf HealthCheckConfig) {
+	users, err := api.DB.GetUsers()
+	if err != nil {
+		fmt.Println("Failed to get users for health checks:", err)
+		return
+	}
+	interval := time.Duration(conf.Interval) * time.Hour
+	checkedBefore := time.Now().Add(-interval).Unix()
+	// Hosts that were busy or limiting requests in this round, and the time when they can be requested again. The
+	// other links on those hosts are postponed without requesting them.
+	busyHosts := make(map[string]time.Time)
+	for _, user := range users {
+		links, err := user.GetLinksToCheck(checkedBefore, conf.BatchSize)
+		if err != nil {
+			fmt.Printf("Failed to get links of %d to check: %v\n", user.ID, err)
+			continue
+		}
+		for _, link := range links {
+			host := link.URL.Host
+			if retryAt, busy := busyHosts[host]; busy {
+				postponeHealthCheck(link, retryAt, interval)
+			} else if retryAt := api.checkLinkHealth(link, interval); !retryAt.IsZero() {
+				busyHosts[host] = retryAt
+			}
+		}
+	}
+}
+
+const healthCheckRetryDelay = time.Hour
+
+// postponeHealthCheck stores the link as checked so that it's due again at the given time, or after
+// healthCheckRetryDelay if that's later. The previous result of the link isn't changed.
+func postponeHealthCheck(link *db.Link, retryAt time.Time, interval time.Duration) {
+	if minRetryAt := time.Now().Add(healthCheckRetryDelay); retryAt.Before(minRetryAt) {
+		retryAt = minRetryAt
+	}
+	link.HealthCheckedAt = retryAt.Add(-interval).Unix()
+	err := link.UpdateHealth()
+	if err != nil {
+		fmt.Printf("Failed to postpone health check of link %d: %v\n", link.ID, err)
+	}
+}
+
+// checkLinkHealth requests the URL of the link and stores whether it still works. Links on hosts that are busy or
+// rate limit the requests are postponed, and the time when the host can be requested again is returned.
+func (api *API) checkLinkHealth(link *db.Link, interval time.Duration) (retryAt time.Time) {
+	result, err := api.Fetcher.Check(link.URL.String())
+	var busy crawler.HostBusyError
+	var httpErr crawler.HTTPError
+	var blocked crawler.BlockedAddressError
+	var disallowed crawler.RobotsDisallowedError
+	switch {
+	case errors.As(err, &busy):
+		postponeHealthCheck(link, busy.RetryAt, interval)
+		return busy.RetryAt
+	case errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusTooManyRequests ||
+		httpErr.StatusCode == http.StatusServiceUnavailable):
+		// The server is overloaded or limiting requests, which doesn't mean that the link is broken.
+		retryAt = time.Now().Add(httpErr.RetryAfter)
+		postponeHealthCheck(link, retryAt, interval)
+		return retryAt
+	case errors.As(err, &blocked), errors.As(err, &disallowed):
+		// The link can't be checked, so just mark it as checked to move on to other links.
+		link.HealthCheckedAt = time.Now().Unix()
+	case result != nil:
+		link.SetHealth(result.StatusCode, result.URL, err == nil)
+	default:
+		link.SetHealth(0, "", false)
+	}
+	err = link.UpdateHealth()
+	if err != nil {
+		fmt.Printf("Failed to store health of link %d: %v\n", link.ID, err)
+	}
+	return
+}
+
+type healthReport struct {
+	BrokenCount     int       `json:"brokenCount"`
+	RedirectedCount int       `json:"redirectedCount"`
+	Broken          []apiLink `json:"broken"`
+	Redirected      []apiLink `json:"redirected"`
+}
+
+// getHealthReportLinks gets the links of the user with the given health check result, newest first.
+func getHealthReportLinks(user *db.User, health db.LinkHealth) ([]apiLink, error) {
+	links, err := user.QueryLinks(db.LinkFilter{Health: health, Order: db.OrderNewest})
+	if err != nil {
+		return nil, err
+	}
+	apiLinks := make([]apiLink, len(links))
+	for index, link := range links {
+		apiLinks[index] = dbToAPILink(link)
+	}
+	return apiLinks, nil
+}
+
+// GetHealthReport is the handler for GET /api/links/health
+func (api *API) GetHealthReport(w http.ResponseWriter, r *http.Request) {
+	user := api.GetUserFromContext(r)
+
+	var report healthReport
+	var err error
+	report.Broken, err = getHealthReportLinks(user, db.HealthBroken)
+	if err != nil {
+		internalError(w, "Failed to get broken links of %d: %v", user.ID, err)
+		return
+	}
+	report.Redirected, err = getHealthReportLinks(user, db.HealthRedirected)
+	if err != nil {
+		internalError(w, "Failed to get redirected links of %d: %v", user.ID, err)
+		return
+	}
+	report.BrokenCount = len(report.Broken)
+	report.RedirectedCount = len(report.Redirected)
+	writeJSON(w, http.StatusOK, report)
+}
@@ -17,11 +17,8 @@
 package api
 
 import (
-	"context"
-	"fmt"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
@@ -30,9 +27,12 @@ import (
 
 func (api *API) ImportLinks(w http.ResponseWriter, r *http.Request) {
 	format := r.URL.Query().Get("format")
+	duplicateMode, ok := getDuplicateMode(w, r)
+	if !ok {
+		return
+	}
 
 	var links []*db.Link
-	var ok bool
 	switch format {
 	case "lindeb":
 		links, ok = api.readLindebDump(w, r)
@@ -48,11 +48,41 @@ func (api *API) ImportLinks(w http.ResponseWriter, r *http.Request) {
 
 	user := api.GetUserFromContext(r)
 
-	var apiLinks = make([]apiLink, len(links))
-	for index, link := range links {
+	var apiLinks = make([]apiLink, 0, len(links))
+	for _, link := range links {
+		link.NormalizeURL()
+		if duplicateMode != DuplicateAllow && len(link.NormalizedURL) > 0 {
+			existing, err := user.GetLinksByNormalizedURL(link.NormalizedURL)
+			if err != nil {
+				internalError(w, "Failed to find duplicates of link in database: %v", err)
+				return
+			} else if len(existing) > 0 {
+				if duplicateMode == DuplicateReject {
+					continue
+				}
+				// Importing a link that hasn't been read doesn't mark the existing link as unread.
+				var read *bool
+				if link.Read {
+					read = &link.Read
+				}
+				err = api.mergeSavedLink(existing[0], link.Title, link.Description, read, link.Tags)
+				if err != nil {
+					internalError(w, "Failed to merge imported link into %d: %v", existing[0].ID, err)
+					return
+				}
+				apiLinks = append(apiLinks, dbToAPILink(existing[0]))
+				continue
+			}
+		}
+
+		// Imported titles and descriptions were chosen by the user in the other service, so the crawler keeps them.
+		link.CustomTitle = len(link.Title) > 0
+		link.CustomDescription = len(link.Description) > 0
+		link.CrawlStatus = db.CrawlPending
 		err := link.Insert()
 		if err != nil {
 			internalError(w, "Failed to insert link into database: %v", err)
+			return
 		}
 
 		err = link.UpdateTags(link.Tags)
@@ -62,29 +92,12 @@ func (api *API) ImportLinks(w http.ResponseWriter, r *http.Request) {
 		}
 
 		apiLink := dbToAPILink(link)
-		api.queueElasticImport(apiLink.Copy(), user)
-		apiLinks[index] = apiLink
+		api.enqueueJob(db.JobCrawl, user.ID, apiLink.ID, "")
+		apiLinks = append(apiLinks, apiLink)
 	}
 	writeJSON(w, http.StatusOK, apiLinks)
 }
 
-func (api *API) queueElasticImport(link apiLink, user *db.User) {
-	api.elasticQueue <- func() {
-		link.HTML = readLink(link.URLString)
-		link.Owner = user.ID
-		_, err := api.Elastic.Index().
-			Index(ElasticIndex).
-			Type(ElasticType).
-			Routing(user.IDString()).
-			Id(strconv.Itoa(link.ID)).
-			BodyJson(link).
-			Do(context.Background())
-		if err != nil {
-			fmt.Printf("Elasticsearch error while saving link %d from %d: %v\n", link.ID, user.ID, err)
-		}
-	}
-}
-
 func (api *API) readLindebDump(w http.ResponseWriter, r *http.Request) ([]*db.Link, bool) {
 	user := api.GetUserFromContext(r)
 
@@ -0,0 +1,320 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"maunium.net/go/lindeb/crawler"
+	"maunium.net/go/lindeb/db"
+)
+
+// QueueConfig contains the settings of the background job queue.
+type QueueConfig struct {
+	// Workers is the number of jobs that can run at the same time.
+	Workers int `yaml:"workers"`
+	// MaxAttempts is the number of times a job is tried before it's marked dead.
+	MaxAttempts int `yaml:"max_attempts"`
+	// RetryDelay is the number of seconds to wait before the first retry. The delay is doubled after each attempt.
+	RetryDelay int `yaml:"retry_delay"`
+}
+
+// DefaultQueueConfig contains the queue settings used for fields that are not set in the config.
+var DefaultQueueConfig = QueueConfig{
+	Workers:     3,
+	MaxAttempts: 8,
+	RetryDelay:  30,
+}
+
+const maxRetryDelay = 6 * time.Hour
+const jobPollInterval = 5 * time.Second
+const doneJobRetention = 7 * 24 * time.Hour
+
+// withDefaults returns a copy of the config where unset fields have their default values.
+func (conf QueueConfig) withDefaults() QueueConfig {
+	if conf.Workers <= 0 {
+		conf.Workers = DefaultQueueConfig.Workers
+	}
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = DefaultQueueConfig.MaxAttempts
+	}
+	if conf.RetryDelay <= 0 {
+		conf.RetryDelay = DefaultQueueConfig.RetryDelay
+	}
+	return conf
+}
+
+// retryDelay calculates how long to wait before retrying a job that has failed the given number of times.
+func (conf QueueConfig) retryDelay(attempts int) time.Duration {
+	delay := time.Duration(conf.RetryDelay) * time.Second
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// enqueueJob stores a new background job and wakes up a worker to run it.
+func (api *API) enqueueJob(jobType db.JobType, owner, link int, payload string) {
+	_, err := api.DB.NewJob(jobType, owner, link, payload)
+	if err != nil {
+		fmt.Printf("Failed to queue %s job for link %d from %d: %v\n", jobType, link, owner, err)
+		return
+	}
+	select {
+	case api.jobSignal <- struct{}{}:
+	default:
+	}
+}
+
+// StartJobQueue starts the background job workers. Jobs that were left running by a previous shutdown are retried.
+func (api *API) StartJobQueue() {
+	err := api.DB.ResetRunningJobs()
+	if err != nil {
+		fmt.Println("Failed to reset interrupted jobs:", err)
+	}
+	for i := 0; i < api.Queue.Workers; i++ {
+		go api.jobWorker()
+	}
+	go api.pruneJobs()
+}
+
+func (api *API) jobWorker() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		api.runPendingJobs()
+		select {
+		case <-api.jobSignal:
+		case <-ticker.C:
+		case <-api.stop:
+			api.stop <- true
+			return
+		}
+	}
+}
+
+// runPendingJobs runs jobs until there are no more jobs that are due.
+func (api *API) runPendingJobs() {
+	for {
+		job, err := api.DB.ClaimJob()
+		if err != nil {
+			fmt.Println("Failed to get job from queue:", err)
+			return
+		} else if job == nil {
+			return
+		}
+
+		err = api.runJob(job)
+		var busy crawler.HostBusyError
+		var httpErr crawler.HTTPError
+		if errors.As(err, &busy) {
+			// The host of the link is being crawled by other jobs, so try again later without counting an attempt.
+			err = job.Postpone(busy.RetryAt)
+		} else if err != nil {
+			fmt.Printf("Job %d (%s link %d from %d) failed on attempt %d: %v\n",
+				job.ID, job.Type, job.Link, job.Owner, job.Attempts, err)
+			delay := api.Queue.retryDelay(job.Attempts)
+			if errors.As(err, &httpErr) && httpErr.RetryAfter > delay {
+				delay = httpErr.RetryAfter
+			}
+			err = job.Fail(err, api.Queue.MaxAttempts, delay)
+		} else {
+			err = job.Complete()
+		}
+		if err != nil {
+			fmt.Printf("Failed to update status of job %d: %v\n", job.ID, err)
+		}
+	}
+}
+
+func (api *API) runJob(job *db.Job) error {
+	if job.Type == db.JobDelete {
+		return api.SearchIndex.Delete(job.Owner, job.Link)
+	}
+
+	user := api.DB.GetUser(job.Owner)
+	if user == nil {
+		return nil
+	}
+	link := user.GetLink(job.Link)
+	if link == nil {
+		// The link has been deleted after the job was queued.
+		return nil
+	}
+	switch job.Type {
+	case db.JobCrawl:
+		return api.runCrawlJob(user, link)
+	case db.JobIndex:
+		doc := dbToAPILink(link).toDocument(user.ID, job.Payload)
+		if len(doc.Content) == 0 {
+			return api.SearchIndex.Update(doc)
+		}
+		return api.SearchIndex.Index(doc)
+	default:
+		return fmt.Errorf("unknown job type %s", job.Type)
+	}
+}
+
+// runCrawlJob fetches the page of the link, stores the title, description, crawl status and article data found and
+// indexes the readable text of the page. Failures that may work when retried are returned, so that the job is retried later.
+func (api *API) runCrawlJob(user *db.User, link *db.Link) error {
+	healthCheckedAt := link.HealthCheckedAt
+	content, retryErr := api.crawlLink(link)
+	var busy crawler.HostBusyError
+	if errors.As(retryErr, &busy) {
+		// Nothing was fetched, so there's nothing to store.
+		return retryErr
+	}
+	stored, err := link.UpdateCrawlResult()
+	if err != nil {
+		return fmt.Errorf("failed to store crawl result: %v", err)
+	} else if !stored {
+		// The URL was changed or the link was deleted during the crawl. Changing the URL queues a new crawl job.
+		return nil
+	}
+	// The health is only stored if the crawl found out whether the link works, so that the result of a health check
+	// made during the crawl isn't overwritten with the old values.
+	if link.HealthCheckedAt != healthCheckedAt {
+		err = link.UpdateHealth()
+		if err != nil {
+			return fmt.Errorf("failed to store health: %v", err)
+		}
+	}
+	// The tags, read status and other fields may have been changed during the crawl, so the link is reloaded to
+	// index the current values. Edits made after this are indexed by the index jobs they queue.
+	link = user.GetLink(link.ID)
+	if link == nil {
+		return nil
+	}
+	err = api.SearchIndex.Index(dbToAPILink(link).toDocument(user.ID, content))
+	if err != nil {
+		return err
+	}
+	return retryErr
+}
+
+// pruneJobs periodically deletes old successfully completed jobs.
+func (api *API) pruneJobs() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		err := api.DB.DeleteDoneJobs(time.Now().Add(-doneJobRetention))
+		if err != nil {
+			fmt.Println("Failed to delete old jobs:", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-api.stop:
+			api.stop <- true
+			return
+		}
+	}
+}
+
+type apiJob struct {
+	ID        int    `json:"id"`
+	Type      string `json:"type"`
+	Owner     int    `json:"owner"`
+	Link      int    `json:"link"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError,omitempty"`
+	RunAt     int64  `json:"runAt"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+func dbToAPIJob(job *db.Job) apiJob {
+	return apiJob{
+		ID:        job.ID,
+		Type:      string(job.Type),
+		Owner:     job.Owner,
+		Link:      job.Link,
+		Status:    string(job.Status),
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		RunAt:     job.RunAt,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
+
+// GetLinkIndexStatus is the handler for GET /api/link/<id>/status
+func (api *API) GetLinkIndexStatus(w http.ResponseWriter, r *http.Request) {
+	job := api.GetLinkFromContext(r).GetLinkJob()
+	if job == nil {
+		http.Error(w, "No indexing jobs found for link.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, dbToAPIJob(job))
+}
+
+// ListJobs is the handler for GET /api/admin/jobs
+func (api *API) ListJobs(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if len(status) == 0 {
+		status = string(db.JobDead)
+	}
+	limit, ok := getQueryInt(w, r, "limit", 100)
+	if !ok {
+		return
+	}
+
+	jobs, err := api.DB.GetJobs(db.JobStatus(status), limit)
+	if err != nil {
+		internalError(w, "Failed to get jobs from database: %v", err)
+		return
+	}
+	apiJobs := make([]apiJob, len(jobs))
+	for index, job := range jobs {
+		apiJobs[index] = dbToAPIJob(job)
+	}
+	writeJSON(w, http.StatusOK, apiJobs)
+}
+
+// RetryJob is the handler for POST /api/admin/job/<id>/retry
+func (api *API) RetryJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := getMuxIntVar(w, r, "id", "Job ID")
+	if !ok {
+		return
+	}
+	job := api.DB.GetJob(id)
+	if job == nil {
+		http.Error(w, "Job not found.", http.StatusNotFound)
+		return
+	} else if job.Status == db.JobRunning {
+		http.Error(w, "Job is currently running.", http.StatusConflict)
+		return
+	}
+
+	err := job.Retry()
+	if err != nil {
+		internalError(w, "Failed to update job %d: %v", job.ID, err)
+		return
+	}
+	select {
+	case api.jobSignal <- struct{}{}:
+	default:
+	}
+	writeJSON(w, http.StatusOK, dbToAPIJob(job))
+}
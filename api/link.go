@@ -26,6 +26,7 @@ import (
 	"net/url"
 
 	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/search"
 )
 
 type apiLink struct {
@@ -36,9 +37,50 @@ type apiLink struct {
 	URLString   string   `json:"url"`
 	Domain      string   `json:"domain"`
 	Tags        []string `json:"tags"`
+	// Read is a pointer so that edits can leave the read state unchanged by omitting it.
+	Read *bool `json:"read"`
+	// Highlights contains the matched fragments of the title, description and content in search results.
+	Highlights map[string][]string `json:"highlights,omitempty"`
+	// Score is the similarity of the link in related link results.
+	Score float64 `json:"score,omitempty"`
+
+	// The crawl state is not stored in the search index, so it's left out of search results.
+	CrawlStatus string `json:"crawlStatus,omitempty"`
+	CrawledAt   int64  `json:"crawledAt,omitempty"`
+	HTTPStatus  int    `json:"httpStatus,omitempty"`
+
+	WordCount int `json:"wordCount,omitempty"`
+	// ReadingTime is the estimated time to read the page in minutes.
+	ReadingTime int    `json:"readingTime,omitempty"`
+	Language    string `json:"language,omitempty"`
+	Author      string `json:"author,omitempty"`
+	PublishedAt int64  `json:"publishedAt,omitempty"`
+	// CanonicalURL is the preferred URL of the page according to the page itself.
+	CanonicalURL string `json:"canonicalUrl,omitempty"`
+	ImageURL     string `json:"imageUrl,omitempty"`
+	SiteName     string `json:"siteName,omitempty"`
+
+	// The latest health check result, which is also left out of search results.
+	HealthStatus    int    `json:"healthStatus,omitempty"`
+	FinalURL        string `json:"finalUrl,omitempty"`
+	HealthCheckedAt int64  `json:"healthCheckedAt,omitempty"`
+	HealthFailures  int    `json:"healthFailures,omitempty"`
+
+	// ArchivedAt is the time of the latest snapshot in the archive, or zero if the page hasn't been archived.
+	ArchivedAt int64 `json:"archivedAt,omitempty"`
+	// FaviconURL and ThumbnailURL are the API paths of the cached favicon and preview image thumbnails.
+	FaviconURL   string `json:"faviconUrl,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+	// NormalizedURL is the URL used to find duplicates of the link.
+	NormalizedURL string `json:"normalizedUrl,omitempty"`
+}
+
+// wordsPerMinute is the reading speed used to estimate the reading time of pages.
+const wordsPerMinute = 200
 
-	Owner int    `json:"owner,omitempty"`
-	HTML  string `json:"html,omitempty"`
+// readingTime estimates how many minutes it takes to read the given number of words, rounded up.
+func readingTime(wordCount int) int {
+	return (wordCount + wordsPerMinute - 1) / wordsPerMinute
 }
 
 func dbToAPILink(dbLink *db.Link) apiLink {
@@ -58,6 +100,30 @@ func dbToAPILink(dbLink *db.Link) apiLink {
 		URLString:   urlStr,
 		Domain:      domain,
 		Tags:        dbLink.Tags,
+		Read:        &dbLink.Read,
+		CrawlStatus: string(dbLink.CrawlStatus),
+		CrawledAt:   dbLink.CrawledAt,
+		HTTPStatus:  dbLink.HTTPStatus,
+		WordCount:   dbLink.WordCount,
+		ReadingTime: readingTime(dbLink.WordCount),
+		Language:    dbLink.Language,
+		Author:      dbLink.Author,
+		PublishedAt: dbLink.PublishedAt,
+
+		CanonicalURL: dbLink.CanonicalURL,
+		ImageURL:     dbLink.ImageURL,
+		SiteName:     dbLink.SiteName,
+
+		HealthStatus:    dbLink.HealthStatus,
+		FinalURL:        dbLink.FinalURL,
+		HealthCheckedAt: dbLink.HealthCheckedAt,
+		HealthFailures:  dbLink.HealthFailures,
+
+		ArchivedAt:   dbLink.ArchivedAt,
+		FaviconURL:   thumbnailPath(dbLink, "favicon", dbLink.FaviconHash),
+		ThumbnailURL: thumbnailPath(dbLink, "thumbnail", dbLink.ThumbnailHash),
+
+		NormalizedURL: dbLink.NormalizedURL,
 	}
 }
 
@@ -70,20 +136,44 @@ func apiToDBLink(user *db.User, apiLink apiLink) *db.Link {
 		Timestamp:   apiLink.Timestamp,
 		URL:         url,
 		Tags:        apiLink.Tags,
+		Read:        apiLink.Read != nil && *apiLink.Read,
 		Owner:       user,
 		DB:          user.DB,
 	}
 }
 
-func (al apiLink) Copy() apiLink {
+func documentToAPILink(doc search.Document) apiLink {
+	if doc.Tags == nil {
+		doc.Tags = []string{}
+	}
 	return apiLink{
+		ID:          doc.ID,
+		Title:       doc.Title,
+		Description: doc.Description,
+		Timestamp:   doc.Timestamp,
+		URLString:   doc.URL,
+		Domain:      doc.Domain,
+		Tags:        doc.Tags,
+		Read:        &doc.Read,
+		Language:    doc.Language,
+		Highlights:  doc.Highlights,
+		Score:       doc.Score,
+	}
+}
+
+func (al apiLink) toDocument(owner int, content string) search.Document {
+	return search.Document{
 		ID:          al.ID,
+		Owner:       owner,
+		URL:         al.URLString,
+		Domain:      al.Domain,
 		Title:       al.Title,
 		Description: al.Description,
-		Timestamp:   al.Timestamp,
-		URLString:   al.URLString,
-		Domain:      al.Domain,
 		Tags:        al.Tags,
+		Timestamp:   al.Timestamp,
+		Read:        al.Read != nil && *al.Read,
+		Content:     content,
+		Language:    al.Language,
 	}
 }
 
@@ -109,9 +199,6 @@ func (api *API) ValidateLink(w http.ResponseWriter, link apiLink) bool {
 	return false
 }
 
-const ElasticIndex = "lindeb"
-const ElasticType = "link"
-
 // SaveLink is a handler for POST /api/link/save
 func (api *API) SaveLink(w http.ResponseWriter, r *http.Request) {
 	inputLink := apiLink{}
@@ -129,6 +216,10 @@ func (api *API) SaveLink(w http.ResponseWriter, r *http.Request) {
 	if !api.ValidateLink(w, inputLink) {
 		return
 	}
+	duplicateMode, ok := getDuplicateMode(w, r)
+	if !ok {
+		return
+	}
 
 	user := api.GetUserFromContext(r)
 	link := user.BlankLink()
@@ -139,14 +230,33 @@ func (api *API) SaveLink(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Malformed URL: %v", err), http.StatusBadRequest)
 		return
 	}
+	link.NormalizeURL()
 
-	htmlBody := scrapeLink(link)
+	if duplicateMode != DuplicateAllow && len(link.NormalizedURL) > 0 {
+		existing, err := user.GetLinksByNormalizedURL(link.NormalizedURL)
+		if err != nil {
+			internalError(w, "Failed to find duplicates of link in database: %v", err)
+			return
+		} else if len(existing) > 0 {
+			api.saveDuplicateLink(w, duplicateMode, existing[0], inputLink)
+			return
+		}
+	}
+
+	// The page is crawled in the background, so the URL is used as the title until the real one is found.
+	link.Title = link.URL.String()
 	if len(inputLink.Title) > 0 {
 		link.Title = inputLink.Title
+		link.CustomTitle = true
 	}
 	if len(inputLink.Description) > 0 {
 		link.Description = inputLink.Description
+		link.CustomDescription = true
+	}
+	if inputLink.Read != nil {
+		link.Read = *inputLink.Read
 	}
+	link.CrawlStatus = db.CrawlPending
 	link.Timestamp = time.Now().Unix()
 
 	err = link.Insert()
@@ -164,20 +274,22 @@ func (api *API) SaveLink(w http.ResponseWriter, r *http.Request) {
 	apiLink := dbToAPILink(link)
 	writeJSON(w, http.StatusCreated, apiLink)
 
-	api.elasticQueue <- func() {
-		apiLink.HTML = htmlBody
-		apiLink.Owner = user.ID
-		_, err = api.Elastic.Index().
-			Index(ElasticIndex).
-			Type(ElasticType).
-			Routing(user.IDString()).
-			Id(link.IDString()).
-			BodyJson(apiLink).
-			Do(context.Background())
-		if err != nil {
-			fmt.Printf("Elasticsearch error while saving link %d from %d: %v", link.ID, user.ID, err)
-		}
+	api.enqueueJob(db.JobCrawl, user.ID, link.ID, "")
+}
+
+// saveDuplicateLink handles saving a link that has already been saved as the given existing link.
+func (api *API) saveDuplicateLink(w http.ResponseWriter, mode DuplicateMode, existing *db.Link, inputLink apiLink) {
+	if mode == DuplicateReject {
+		http.Error(w, fmt.Sprintf("Link already saved as #%d.", existing.ID), http.StatusConflict)
+		return
 	}
+
+	err := api.mergeSavedLink(existing, inputLink.Title, inputLink.Description, inputLink.Read, inputLink.Tags)
+	if err != nil {
+		internalError(w, "Failed to merge link into %d: %v", existing.ID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, dbToAPILink(existing))
 }
 
 // AccessLink is a method proxy for the handlers of /api/link/<id>
@@ -216,23 +328,37 @@ func (api *API) EditLink(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
+	urlChanged := false
 	if len(inputLink.URLString) > 0 {
-		link.URL, err = url.Parse(inputLink.URLString)
+		newURL, err := url.Parse(inputLink.URLString)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Malformed URL: %v", err), http.StatusBadRequest)
 			return
 		}
+		urlChanged = newURL.String() != link.URL.String()
+		link.URL = newURL
 	}
 
-	htmlBody := scrapeLink(link)
-	if len(inputLink.Title) > 0 {
+	// Clients send back the whole link, so only fields that differ from the current values count as set by the user.
+	if len(inputLink.Title) > 0 && inputLink.Title != link.Title {
 		link.Title = inputLink.Title
+		link.CustomTitle = true
 	}
-	if len(inputLink.Description) > 0 {
+	if len(inputLink.Description) > 0 && inputLink.Description != link.Description {
 		link.Description = inputLink.Description
+		link.CustomDescription = true
+	}
+	if inputLink.Read != nil {
+		link.Read = *inputLink.Read
+	}
+	if urlChanged {
+		link.CrawlStatus = db.CrawlPending
+		// The health isn't stored here, as the crawl of the new URL replaces it.
+		link.ResetHealth()
+		link.NormalizeURL()
 	}
 
-	err = link.Update()
+	err = link.UpdateFields(urlChanged)
 	if err != nil {
 		internalError(w, "Failed to update link %d in database: %v", link.ID, err)
 		return
@@ -247,20 +373,52 @@ func (api *API) EditLink(w http.ResponseWriter, r *http.Request) {
 	apiLink := dbToAPILink(link)
 	writeJSON(w, http.StatusOK, apiLink)
 
-	api.elasticQueue <- func() {
-		apiLink.HTML = htmlBody
-		apiLink.Owner = user.ID
-		_, err = api.Elastic.Update().
-			Index(ElasticIndex).
-			Type(ElasticType).
-			Routing(user.IDString()).
-			Id(link.IDString()).
-			Doc(apiLink).
-			Do(context.Background())
+	if urlChanged {
+		api.enqueueJob(db.JobCrawl, user.ID, link.ID, "")
+	} else {
+		// An empty payload keeps the page content that's already in the index.
+		api.enqueueJob(db.JobIndex, user.ID, link.ID, "")
+	}
+}
+
+// GetRelatedLinks is the handler for GET /api/link/<id>/related
+func (api *API) GetRelatedLinks(w http.ResponseWriter, r *http.Request) {
+	user := api.GetUserFromContext(r)
+	link := api.GetLinkFromContext(r)
+
+	size, ok := getQueryInt(w, r, "size", 10)
+	if !ok {
+		return
+	} else if size <= 0 || size > 50 {
+		http.Error(w, "Size must be between 1 and 50.", http.StatusBadRequest)
+		return
+	}
+
+	var links []apiLink
+	if finder, ok := api.SearchIndex.(search.RelatedFinder); ok {
+		docs, err := finder.Related(user.ID, link.ID, size)
 		if err != nil {
-			fmt.Printf("Elasticsearch error while updating link %d from %d: %v", link.ID, user.ID, err)
+			internalError(w, "Search index error while finding links related to %d: %v", link.ID, err)
+			return
+		}
+		links = make([]apiLink, len(docs))
+		for index, doc := range docs {
+			links[index] = documentToAPILink(doc)
+		}
+	} else {
+		// Indexes that can't compare the content fall back to comparing tags and domains in the database.
+		related, err := link.GetRelated(size)
+		if err != nil {
+			internalError(w, "Failed to get links related to %d from database: %v", link.ID, err)
+			return
+		}
+		links = make([]apiLink, len(related))
+		for index, relatedLink := range related {
+			links[index] = dbToAPILink(relatedLink.Link)
+			links[index].Score = relatedLink.Score
 		}
 	}
+	writeJSON(w, http.StatusOK, links)
 }
 
 // DeleteLink is the handler for DELETE /api/link/<id>
@@ -274,15 +432,7 @@ func (api *API) DeleteLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = api.Elastic.Delete().
-		Index(ElasticIndex).
-		Type(ElasticType).
-		Routing(user.IDString()).
-		Id(link.IDString()).
-		Do(context.Background())
-	if err != nil {
-		fmt.Printf("Elasticsearch error while deleting link %d: %v", link.ID, err)
-	}
+	api.enqueueJob(db.JobDelete, user.ID, link.ID, "")
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -0,0 +1,175 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/search"
+)
+
+// ReindexStatus contains the progress of the latest search index rebuild.
+type ReindexStatus struct {
+	Running    bool   `json:"running"`
+	User       int    `json:"user,omitempty"`
+	Recrawl    bool   `json:"recrawl"`
+	Done       int    `json:"done"`
+	Total      int    `json:"total"`
+	StartedAt  int64  `json:"startedAt,omitempty"`
+	FinishedAt int64  `json:"finishedAt,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Reindex rebuilds the search index from the links in the database.
+//
+// If user is not nil, only the links of that user are reindexed. If recrawl is true, the pages are fetched again
+// instead of reusing the page content stored in the current index. The progress function, if not nil, is called after
+// each link is read.
+func (api *API) Reindex(user *db.User, recrawl bool, progress func(done, total int)) error {
+	startedAt := time.Now()
+	users := []*db.User{user}
+	owner := 0
+	if user != nil {
+		owner = user.ID
+	} else {
+		var err error
+		users, err = api.DB.GetUsers()
+		if err != nil {
+			return fmt.Errorf("failed to get users: %v", err)
+		}
+	}
+
+	// All links are loaded before starting so that a database error doesn't leave the index half-built.
+	var links []*db.Link
+	for _, user := range users {
+		userLinks, err := user.GetLinks()
+		if err != nil {
+			return fmt.Errorf("failed to get links of #%d: %v", user.ID, err)
+		}
+		links = append(links, userLinks...)
+	}
+
+	docs := make(chan search.Document, 64)
+	result := make(chan error, 1)
+	go func() {
+		result <- api.SearchIndex.Reindex(owner, docs)
+	}()
+	for index, link := range links {
+		var content string
+		if recrawl {
+			content = api.readContent(link.URL.String())
+		}
+		docs <- dbToAPILink(link).toDocument(link.Owner.ID, content)
+		if progress != nil {
+			progress(index+1, len(links))
+		}
+	}
+	close(docs)
+	err := <-result
+	if err != nil {
+		return err
+	}
+	return api.requeueJobsSince(owner, startedAt)
+}
+
+// requeueJobsSince queues again the jobs of the owner that have been run since the given time. Jobs that ran while a
+// reindex was in progress may have written to an index that was replaced, or had their documents overwritten by
+// the reindex, so they have to be redone after it. If owner is zero, the jobs of all owners are queued.
+func (api *API) requeueJobsSince(owner int, since time.Time) error {
+	jobs, err := api.DB.GetJobsRunSince(owner, since)
+	if err != nil {
+		return fmt.Errorf("failed to get jobs run during reindex: %v", err)
+	}
+	type jobKey struct {
+		jobType     db.JobType
+		owner, link int
+		payload     string
+	}
+	queued := make(map[jobKey]bool)
+	for _, job := range jobs {
+		key := jobKey{job.Type, job.Owner, job.Link, job.Payload}
+		if !queued[key] {
+			queued[key] = true
+			api.enqueueJob(job.Type, job.Owner, job.Link, job.Payload)
+		}
+	}
+	return nil
+}
+
+// GetReindexStatus is the handler for GET /admin/reindex
+func (api *API) GetReindexStatus(w http.ResponseWriter, r *http.Request) {
+	api.reindexLock.Lock()
+	status := api.reindexStatus
+	api.reindexLock.Unlock()
+	writeJSON(w, http.StatusOK, status)
+}
+
+// StartReindex is the handler for POST /admin/reindex
+func (api *API) StartReindex(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getQueryInt(w, r, "user", 0)
+	if !ok {
+		return
+	}
+	recrawl, ok := getQueryBool(w, r, "recrawl")
+	if !ok {
+		return
+	}
+	var user *db.User
+	if userID != 0 {
+		user = api.DB.GetUser(userID)
+		if user == nil {
+			http.Error(w, fmt.Sprintf("User #%d not found.", userID), http.StatusNotFound)
+			return
+		}
+	}
+
+	api.reindexLock.Lock()
+	if api.reindexStatus.Running {
+		api.reindexLock.Unlock()
+		http.Error(w, "A reindex is already running.", http.StatusConflict)
+		return
+	}
+	api.reindexStatus = ReindexStatus{
+		Running:   true,
+		User:      userID,
+		Recrawl:   recrawl,
+		StartedAt: time.Now().Unix(),
+	}
+	status := api.reindexStatus
+	api.reindexLock.Unlock()
+
+	go func() {
+		err := api.Reindex(user, status.Recrawl, func(done, total int) {
+			api.reindexLock.Lock()
+			api.reindexStatus.Done, api.reindexStatus.Total = done, total
+			api.reindexLock.Unlock()
+		})
+		api.reindexLock.Lock()
+		api.reindexStatus.Running = false
+		api.reindexStatus.FinishedAt = time.Now().Unix()
+		if err != nil {
+			api.reindexStatus.Error = err.Error()
+			fmt.Println("Reindex failed:", err)
+		}
+		api.reindexLock.Unlock()
+	}()
+
+	writeJSON(w, http.StatusAccepted, status)
+}
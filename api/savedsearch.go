@@ -0,0 +1,268 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/search"
+)
+
+func (api *API) ValidateSavedSearch(w http.ResponseWriter, savedSearch *db.SavedSearch) bool {
+	if len(savedSearch.Name) == 0 {
+		http.Error(w, "Saved search name is required.", http.StatusBadRequest)
+		return false
+	} else if len(savedSearch.Name) > 255 {
+		http.Error(w, "Saved search name too long.", http.StatusRequestEntityTooLarge)
+		return false
+	} else if len(savedSearch.Search) > 65535 {
+		http.Error(w, "Search query too long.", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if _, err := search.ParseQuery(savedSearch.Search); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid search query: %v", err), http.StatusBadRequest)
+		return false
+	}
+	if _, ok := search.ParseSort(savedSearch.Sort); !ok {
+		http.Error(w, fmt.Sprintf("Unknown sort option: %s", savedSearch.Sort), http.StatusBadRequest)
+		return false
+	}
+	// The tags and domains are stored as comma-separated lists.
+	for index, tag := range savedSearch.Tags {
+		if len(tag) > 32 {
+			http.Error(w, fmt.Sprintf("Tag #%d too long.", index+1), http.StatusRequestEntityTooLarge)
+			return false
+		} else if strings.ContainsRune(tag, ',') {
+			http.Error(w, fmt.Sprintf("Tag #%d contains a comma.", index+1), http.StatusBadRequest)
+			return false
+		}
+	}
+	for index, domain := range savedSearch.Domains {
+		if len(domain) > 255 {
+			http.Error(w, fmt.Sprintf("Domain #%d too long.", index+1), http.StatusRequestEntityTooLarge)
+			return false
+		} else if strings.ContainsRune(domain, ',') {
+			http.Error(w, fmt.Sprintf("Domain #%d contains a comma.", index+1), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// readSavedSearch reads and validates a saved search from the request body.
+func (api *API) readSavedSearch(w http.ResponseWriter, r *http.Request) (*db.SavedSearch, bool) {
+	input := &db.SavedSearch{}
+	if !readJSON(w, r, &input) || !api.ValidateSavedSearch(w, input) {
+		return nil, false
+	}
+	if input.Tags == nil {
+		input.Tags = []string{}
+	}
+	if input.Domains == nil {
+		input.Domains = []string{}
+	}
+	return input, true
+}
+
+// ListSavedSearches is the handler for GET /api/searches
+func (api *API) ListSavedSearches(w http.ResponseWriter, r *http.Request) {
+	user := api.GetUserFromContext(r)
+	searches, err := user.GetSavedSearches()
+	if err != nil {
+		internalError(w, "Failed to fetch saved searches of %d: %v", user.ID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, searches)
+}
+
+// AddSavedSearch is the handler for POST /api/searches
+func (api *API) AddSavedSearch(w http.ResponseWriter, r *http.Request) {
+	user := api.GetUserFromContext(r)
+
+	input, ok := api.readSavedSearch(w, r)
+	if !ok {
+		return
+	}
+	savedSearch := user.BlankSavedSearch()
+	savedSearch.Name = input.Name
+	savedSearch.Search = input.Search
+	savedSearch.Tags = input.Tags
+	savedSearch.Domains = input.Domains
+	savedSearch.ExclusiveTags = input.ExclusiveTags
+	savedSearch.Sort = input.Sort
+
+	err := savedSearch.Insert()
+	if err != nil {
+		internalError(w, "Failed to insert saved search by %d into database: %v", user.ID, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, savedSearch)
+}
+
+// AccessSavedSearch is a method proxy for the handlers of /api/searches/<id>
+func (api *API) AccessSavedSearch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, api.GetSavedSearchFromContext(r))
+	case http.MethodPut:
+		api.EditSavedSearch(w, r)
+	case http.MethodDelete:
+		api.DeleteSavedSearch(w, r)
+	default:
+		// Invalid methods should be prevented at the router level, so just panic if the router is misconfigured.
+		panic("Fatal: AccessSavedSearch called with invalid method.")
+	}
+}
+
+// EditSavedSearch is the handler for PUT /api/searches/<id>. All the fields of the saved search are replaced.
+func (api *API) EditSavedSearch(w http.ResponseWriter, r *http.Request) {
+	savedSearch := api.GetSavedSearchFromContext(r)
+
+	input, ok := api.readSavedSearch(w, r)
+	if !ok {
+		return
+	}
+	savedSearch.Name = input.Name
+	savedSearch.Search = input.Search
+	savedSearch.Tags = input.Tags
+	savedSearch.Domains = input.Domains
+	savedSearch.ExclusiveTags = input.ExclusiveTags
+	savedSearch.Sort = input.Sort
+
+	err := savedSearch.Update()
+	if err != nil {
+		internalError(w, "Failed to update saved search %d in database: %v", savedSearch.ID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, savedSearch)
+}
+
+// DeleteSavedSearch is the handler for DELETE /api/searches/<id>
+func (api *API) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	savedSearch := api.GetSavedSearchFromContext(r)
+	err := savedSearch.Delete()
+	if err != nil {
+		internalError(w, "Failed to delete saved search %d from database: %v", savedSearch.ID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// statusRecorder is a response writer that remembers the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// GetSavedSearchLinks is the handler for GET /api/searches/<id>/links
+//
+// The saved query and filters are passed to BrowseLinks, along with the pagination and other options of the request.
+// If the new parameter is set, only links saved or edited since the previous request with the parameter are returned.
+func (api *API) GetSavedSearchLinks(w http.ResponseWriter, r *http.Request) {
+	savedSearch := api.GetSavedSearchFromContext(r)
+
+	params := url.Values{}
+	for key, values := range r.URL.Query() {
+		params[key] = values
+	}
+	params.Set("search", savedSearch.Search)
+	params["tag"] = savedSearch.Tags
+	params["domain"] = savedSearch.Domains
+	params.Del("exclusivetags")
+	if savedSearch.ExclusiveTags {
+		params.Set("exclusivetags", "true")
+	}
+	if len(params.Get("sort")) == 0 {
+		params.Set("sort", savedSearch.Sort)
+	}
+	onlyNew := len(params.Get("new")) > 0
+	if onlyNew && len(params.Get("since")) == 0 {
+		params.Set("since", strconv.FormatInt(savedSearch.CheckedAt, 10))
+	}
+
+	browseRequest := r.WithContext(r.Context())
+	browseURL := *r.URL
+	browseURL.RawQuery = params.Encode()
+	browseRequest.URL = &browseURL
+
+	checkedAt := time.Now()
+	rec := &statusRecorder{w, http.StatusOK}
+	api.BrowseLinks(rec, browseRequest)
+	if onlyNew && rec.status == http.StatusOK {
+		err := savedSearch.MarkChecked(checkedAt)
+		if err != nil {
+			fmt.Printf("Failed to update check time of saved search %d: %v\n", savedSearch.ID, err)
+		}
+	}
+}
+
+// SavedSearchMiddleware provides a HTTP handler middleware that loads the data of the saved search with the
+// requested ID to the request context.
+//
+// You must call the authentication middleware BEFORE this function, as this depends on the user being logged in.
+//
+// If the request path doesn't contain the id field, HTTP Bad Request is returned.
+// If the requested saved search does not exist or is not owned by the current user, HTTP Not Found is returned.
+// In both error cases, the next handler is not called.
+func (api *API) SavedSearchMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := api.GetUserFromContext(r)
+
+		id, ok := getMuxIntVar(w, r, "id", "Saved search ID")
+		if !ok {
+			return
+		}
+
+		savedSearch := user.GetSavedSearch(id)
+		if savedSearch == nil {
+			http.Error(w, fmt.Sprintf(`Saved search #%d not found.`, id), http.StatusNotFound)
+			return
+		}
+		newContext := context.WithValue(r.Context(), "savedSearch", savedSearch)
+		next.ServeHTTP(w, r.WithContext(newContext))
+	})
+}
+
+// GetSavedSearchFromContext gets the database saved search object from the context of the given request.
+//
+// Calling this function with a request that did not go through the saved search getter middleware is strictly
+// forbidden and will cause a panic.
+func (api *API) GetSavedSearchFromContext(r *http.Request) *db.SavedSearch {
+	savedSearchInterface := r.Context().Value("savedSearch")
+	if savedSearchInterface == nil {
+		panic("Fatal: Called GetSavedSearchFromContext from handler without saved search getter middleware " +
+			"(saved search not in context)")
+	}
+	savedSearch, ok := savedSearchInterface.(*db.SavedSearch)
+	if !ok {
+		panic("Fatal: Called GetSavedSearchFromContext from handler without saved search getter middleware " +
+			"(context saved search is wrong type)")
+	}
+	return savedSearch
+}
@@ -0,0 +1,89 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"maunium.net/go/lindeb/search"
+)
+
+type apiTitleSuggestion struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+	URLString string `json:"url"`
+}
+
+type suggestResponse struct {
+	Tags    []string             `json:"tags"`
+	Domains []string             `json:"domains"`
+	Titles  []apiTitleSuggestion `json:"titles"`
+}
+
+// Suggest is the handler for GET /api/suggest
+func (api *API) Suggest(w http.ResponseWriter, r *http.Request) {
+	user := api.GetUserFromContext(r)
+
+	size, ok := getQueryInt(w, r, "size", 5)
+	if !ok {
+		return
+	} else if size <= 0 || size > 20 {
+		http.Error(w, "Size must be between 1 and 20.", http.StatusBadRequest)
+		return
+	}
+
+	resp := suggestResponse{[]string{}, []string{}, []apiTitleSuggestion{}}
+	prefix := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(prefix) == 0 {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	var err error
+	resp.Tags, err = user.SuggestTags(prefix, size)
+	if err != nil {
+		internalError(w, "Failed to get tag suggestions for %d: %v", user.ID, err)
+		return
+	}
+	resp.Domains, err = user.SuggestDomains(prefix, size)
+	if err != nil {
+		internalError(w, "Failed to get domain suggestions for %d: %v", user.ID, err)
+		return
+	}
+
+	if suggester, ok := api.SearchIndex.(search.TitleSuggester); ok {
+		docs, err := suggester.SuggestTitles(user.ID, prefix, size)
+		if err != nil {
+			internalError(w, "Search index error while getting title suggestions for %d: %v", user.ID, err)
+			return
+		}
+		for _, doc := range docs {
+			resp.Titles = append(resp.Titles, apiTitleSuggestion{doc.ID, doc.Title, doc.URL})
+		}
+	} else {
+		links, err := user.SuggestLinkTitles(prefix, size)
+		if err != nil {
+			internalError(w, "Failed to get title suggestions for %d: %v", user.ID, err)
+			return
+		}
+		for _, link := range links {
+			resp.Titles = append(resp.Titles, apiTitleSuggestion{link.ID, link.Title, link.URL.String()})
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
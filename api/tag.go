@@ -149,7 +149,7 @@ func (api *API) DeleteTag(w http.ResponseWriter, r *http.Request) {
 
 	deleteLinks := len(r.URL.Query().Get("delete-links")) > 0
 	if deleteLinks {
-		// I'd use a simpler DELETE query with some JOINs, but we also need to delete the links from Elasticsearch,
+		// I'd use a simpler DELETE query with some JOINs, but we also need to delete the links from the search index,
 		// so looping over the links is necessary.
 		links, err := tag.GetTaggedLinks()
 		if err != nil {
@@ -162,16 +162,9 @@ func (api *API) DeleteTag(w http.ResponseWriter, r *http.Request) {
 			err = link.Delete()
 			if err != nil {
 				errors = append(errors, err)
+				continue
 			}
-			_, err = api.Elastic.Delete().
-				Index(ElasticIndex).
-				Type(ElasticType).
-				Routing(user.IDString()).
-				Id(link.IDString()).
-				Do(context.Background())
-			if err != nil {
-				errors = append(errors, err)
-			}
+			api.enqueueJob(db.JobDelete, user.ID, link.ID, "")
 		}
 		if len(errors) > 0 {
 			internalError(w, "Errors occurred while deleting links tagged with %d", tag.ID)
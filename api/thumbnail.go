@@ -0,0 +1,197 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"maunium.net/go/lindeb/archive"
+	"maunium.net/go/lindeb/crawler"
+	"maunium.net/go/lindeb/db"
+)
+
+// ThumbnailConfig contains the settings of the favicon and preview image cache.
+type ThumbnailConfig struct {
+	// Enabled determines whether favicons and preview images are fetched when pages are crawled.
+	Enabled bool `yaml:"enabled"`
+	// Path is the directory where the thumbnails are stored.
+	Path string `yaml:"path"`
+	// FaviconSize is the width and height of favicon thumbnails in pixels.
+	FaviconSize int `yaml:"favicon_size"`
+	// Width and Height are the size of preview image thumbnails in pixels.
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+	// MaxImageSize is the maximum size of the original images in bytes.
+	MaxImageSize int64 `yaml:"max_image_size"`
+}
+
+// DefaultThumbnailConfig contains the thumbnail settings used for fields that are not set in the config.
+var DefaultThumbnailConfig = ThumbnailConfig{
+	Path:         "thumbnails",
+	FaviconSize:  64,
+	Width:        400,
+	Height:       210,
+	MaxImageSize: 5 * 1024 * 1024,
+}
+
+// withDefaults returns a copy of the config where unset fields have their default values.
+func (conf ThumbnailConfig) withDefaults() ThumbnailConfig {
+	if len(conf.Path) == 0 {
+		conf.Path = DefaultThumbnailConfig.Path
+	}
+	if conf.FaviconSize <= 0 {
+		conf.FaviconSize = DefaultThumbnailConfig.FaviconSize
+	}
+	if conf.Width <= 0 {
+		conf.Width = DefaultThumbnailConfig.Width
+	}
+	if conf.Height <= 0 {
+		conf.Height = DefaultThumbnailConfig.Height
+	}
+	if conf.MaxImageSize <= 0 {
+		conf.MaxImageSize = DefaultThumbnailConfig.MaxImageSize
+	}
+	return conf
+}
+
+// ThumbnailCache stores the scaled down favicons and preview images of links.
+type ThumbnailCache struct {
+	Store  *archive.Store
+	Config ThumbnailConfig
+}
+
+// Open opens the thumbnail cache configured in the config. If the cache isn't enabled, nil is returned.
+func (conf ThumbnailConfig) Open() (*ThumbnailCache, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	conf = conf.withDefaults()
+	store, err := archive.NewStore(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &ThumbnailCache{Store: store, Config: conf}, nil
+}
+
+// thumbnailCacheTime is how long browsers can cache thumbnails without checking if they've changed.
+const thumbnailCacheTime = 24 * time.Hour
+
+// cacheLinkImages fetches the favicon of the website and the preview image of the page, and stores thumbnails of them
+// in the cache. If an image can't be fetched because of a temporary error, the previous thumbnail is kept.
+func (api *API) cacheLinkImages(link *db.Link, pageURL string, meta *crawler.Metadata) {
+	if api.Thumbnails == nil {
+		return
+	}
+	conf := api.Thumbnails.Config
+	link.FaviconHash = api.cacheImage(link, "favicon", crawler.FaviconURL(pageURL, meta),
+		conf.FaviconSize, conf.FaviconSize, link.FaviconHash)
+	link.ThumbnailHash = api.cacheImage(link, "preview image", link.ImageURL,
+		conf.Width, conf.Height, link.ThumbnailHash)
+}
+
+// cacheImage fetches the image at the given URL, scales it to the given size and stores it in the thumbnail cache. The
+// hash of the thumbnail is returned. If the image can't be fetched, either the previous hash or an empty string is
+// returned depending on whether the error may be temporary.
+func (api *API) cacheImage(link *db.Link, kind, imageURL string, width, height int, previous string) string {
+	if len(imageURL) == 0 {
+		return ""
+	}
+	img, err := api.Fetcher.FetchImage(imageURL, api.Thumbnails.Config.MaxImageSize)
+	if err != nil {
+		fmt.Printf("Failed to fetch %s of %s: %v\n", kind, link.URL, err)
+		if isTemporaryFetchError(err) {
+			return previous
+		}
+		return ""
+	}
+	data, _, err := crawler.EncodeThumbnail(crawler.Thumbnail(img, width, height))
+	if err != nil {
+		fmt.Printf("Failed to encode %s of %s: %v\n", kind, link.URL, err)
+		return previous
+	}
+	hash, err := api.Thumbnails.Store.Put(data)
+	if err != nil {
+		fmt.Printf("Failed to store %s of %s: %v\n", kind, link.URL, err)
+		return previous
+	}
+	return hash
+}
+
+// isTemporaryFetchError checks if fetching something may work if it's retried later.
+func isTemporaryFetchError(err error) bool {
+	var busy crawler.HostBusyError
+	var httpErr crawler.HTTPError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &busy), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests
+	default:
+		return false
+	}
+}
+
+// thumbnailPath gets the API path of a thumbnail of the link, or an empty string if the thumbnail isn't cached.
+func thumbnailPath(link *db.Link, kind, hash string) string {
+	if len(hash) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("/link/%d/%s", link.ID, kind)
+}
+
+// serveThumbnail sends the thumbnail with the given hash from the cache.
+func (api *API) serveThumbnail(w http.ResponseWriter, r *http.Request, link *db.Link, hash string) {
+	if api.Thumbnails == nil || len(hash) == 0 {
+		http.Error(w, "Image not found.", http.StatusNotFound)
+		return
+	}
+	file, err := api.Thumbnails.Store.Open(hash)
+	if os.IsNotExist(err) {
+		http.Error(w, "Image not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		internalError(w, "Failed to open thumbnail of link %d: %v", link.ID, err)
+		return
+	}
+	defer file.Close()
+
+	header := w.Header()
+	// The thumbnails are stored by hash, so the hash works as an ETag.
+	header.Set("ETag", `"`+hash+`"`)
+	header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(thumbnailCacheTime/time.Second)))
+	header.Set("X-Content-Type-Options", "nosniff")
+	// The content type is detected from the data by ServeContent.
+	http.ServeContent(w, r, "", time.Unix(link.CrawledAt, 0), file)
+}
+
+// GetLinkFavicon is the handler for GET /api/link/<id>/favicon
+func (api *API) GetLinkFavicon(w http.ResponseWriter, r *http.Request) {
+	link := api.GetLinkFromContext(r)
+	api.serveThumbnail(w, r, link, link.FaviconHash)
+}
+
+// GetLinkThumbnail is the handler for GET /api/link/<id>/thumbnail
+func (api *API) GetLinkThumbnail(w http.ResponseWriter, r *http.Request) {
+	link := api.GetLinkFromContext(r)
+	api.serveThumbnail(w, r, link, link.ThumbnailHash)
+}
@@ -17,97 +17,144 @@
 package api
 
 import (
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
-	"strings"
-
-	"golang.org/x/net/html"
+	"maunium.net/go/lindeb/crawler"
 	"maunium.net/go/lindeb/db"
 )
 
-func readLink(url string) string {
-	resp, err := http.Get(url)
+// readContent fetches the page at the given URL and extracts the readable text. If the page can't be fetched, an
+// empty string is returned.
+func (api *API) readContent(url string) string {
+	page, err := api.Fetcher.Fetch(url)
 	if err != nil {
+		fmt.Printf("Failed to fetch %s: %v\n", url, err)
 		return ""
 	}
-
-	defer resp.Body.Close()
-
-	rawBody, err := ioutil.ReadAll(resp.Body)
+	_, article, err := page.Extract()
 	if err != nil {
-		return ""
+		fmt.Printf("Failed to extract content of %s: %v\n", url, err)
 	}
-
-	return string(rawBody)
+	return article.Text
 }
 
-func scrapeLink(link *db.Link) (body string) {
-	body = readLink(link.URL.String())
-	if len(body) == 0 {
-		link.Title = "Unreachable website"
-		link.Description = "The lindeb crawler could not reach this URL."
-		return
+// crawlLink fetches the page of the given link and fills in the crawl status and article data of the link. The title
+// and description are replaced with the ones found on the page, unless they were set by the user. The readable text
+// of the page is returned for indexing.
+//
+// If the archive is enabled, a snapshot of the page is stored in it, and if the thumbnail cache is enabled, the
+// favicon and preview image of the page are fetched into it.
+//
+// The returned error is only non-nil if fetching the page failed in a way that may work when retried later, such as
+// a timeout or a server error. If the host of the page is busy, a crawler.HostBusyError is returned and the link is
+// left unchanged.
+func (api *API) crawlLink(link *db.Link) (content string, retryErr error) {
+	page, err := api.Fetcher.Fetch(link.URL.String())
+	var busy crawler.HostBusyError
+	if errors.As(err, &busy) {
+		return "", err
 	}
-
-	link.Title, link.Description = findMetadata(body)
-	if len(link.Title) == 0 {
-		link.Title = link.URL.String()
+	link.CrawledAt = time.Now().Unix()
+	link.HTTPStatus = 0
+	link.WordCount, link.Language, link.Author, link.PublishedAt = 0, "", "", 0
+	link.CanonicalURL, link.ImageURL, link.SiteName = "", "", ""
+	if page != nil {
+		link.HTTPStatus = page.StatusCode
 	}
-	return
-}
+	recordCrawlHealth(link, page, err)
+	if err == nil {
+		link.CrawlStatus = db.CrawlDone
+		api.archivePage(link, page)
+		meta, article, err := page.Extract()
+		if err != nil {
+			// The page was fetched, so what could be extracted is still stored.
+			fmt.Printf("Failed to extract content of %s: %v\n", link.URL, err)
+		}
+		if len(meta.Title) == 0 {
+			meta.Title = link.URL.String()
+		}
+		setCrawledMetadata(link, meta.Title, meta.Description)
+		link.CanonicalURL = meta.CanonicalURL
+		link.ImageURL = meta.ImageURL
+		link.SiteName = meta.SiteName
+		link.NormalizedURL = canonicalNormalizedURL(link, meta)
+		api.cacheLinkImages(link, page.URL, meta)
 
-func findMetadata(body string) (title string, description string) {
-	t := html.NewTokenizer(strings.NewReader(body))
-	for {
-		tokenType := t.Next()
-		if tokenType == html.ErrorToken {
-			return
+		link.WordCount = article.WordCount
+		link.Language = article.Language
+		// The metadata is more reliable than the byline and date guessed from the page content.
+		link.Author = meta.Author
+		if len(link.Author) == 0 {
+			link.Author = article.Byline
+		}
+		published := meta.Published
+		if published.IsZero() {
+			published = article.Published
 		}
-		if tokenType != html.SelfClosingTagToken && tokenType != html.StartTagToken && tokenType != html.EndTagToken {
-			continue
+		if !published.IsZero() {
+			link.PublishedAt = published.Unix()
 		}
-		token := t.Token()
+		return article.Text, nil
+	}
+	fmt.Printf("Failed to fetch %s: %v\n", link.URL, err)
 
-		switch token.Data {
-		case "meta":
-			if len(token.Attr) != 2 {
-				break
-			}
-			var property string
-			var content string
-			for _, attr := range token.Attr {
-				attr.Key = cleanStr(attr.Key)
-				if attr.Key == "property" || attr.Key == "name" {
-					property = attr.Val
-				}
-				if attr.Key == "content" {
-					content = attr.Val
-				}
-			}
-			switch cleanStr(property) {
-			case "og:title":
-				title = content
-			case "og:description":
-				description = html.UnescapeString(content)
-			case "description":
-				if len(description) == 0 {
-					description = html.UnescapeString(content)
-				}
-			}
-		case "title":
-			if tokenType == html.StartTagToken {
-				t.Next()
-				token = t.Token()
-				if len(title) == 0 {
-					title = token.Data
-				}
-			}
+	var blocked crawler.BlockedAddressError
+	var disallowed crawler.RobotsDisallowedError
+	var httpErr crawler.HTTPError
+	var typeErr crawler.UnsupportedContentTypeError
+	switch {
+	case errors.As(err, &blocked):
+		link.CrawlStatus = db.CrawlBlocked
+		setCrawledMetadata(link, "Blocked address",
+			"The lindeb crawler is not allowed to fetch this URL, as it points to a private network.")
+		return "", nil
+	case errors.As(err, &disallowed):
+		link.CrawlStatus = db.CrawlDisallowed
+		setCrawledMetadata(link, link.URL.String(),
+			"The robots.txt file of the website does not allow the lindeb crawler to fetch this URL.")
+		return "", nil
+	case errors.As(err, &typeErr):
+		// The page exists, it just isn't something the crawler can read.
+		link.CrawlStatus = db.CrawlDone
+		setCrawledMetadata(link, link.URL.String(), "")
+		return "", nil
+	case errors.As(err, &httpErr):
+		if httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests {
+			retryErr = err
 		}
+	case !errors.Is(err, crawler.ErrTooManyRedirects):
+		retryErr = err
+	}
+	link.CrawlStatus = db.CrawlFailed
+	setCrawledMetadata(link, "Unreachable website", "The lindeb crawler could not reach this URL.")
+	return "", retryErr
+}
+
+// recordCrawlHealth records the result of a crawl as a health check of the link. The link is healthy if the page was
+// fetched, even if it couldn't be read. Crawls that the crawler wasn't allowed to do don't count as checks.
+func recordCrawlHealth(link *db.Link, page *crawler.Page, err error) {
+	var blocked crawler.BlockedAddressError
+	var disallowed crawler.RobotsDisallowedError
+	var typeErr crawler.UnsupportedContentTypeError
+	if errors.As(err, &blocked) || errors.As(err, &disallowed) {
+		return
+	}
+	statusCode, finalURL := 0, ""
+	if page != nil {
+		statusCode, finalURL = page.StatusCode, page.URL
 	}
-	return
+	link.SetHealth(statusCode, finalURL, err == nil || errors.As(err, &typeErr))
 }
 
-func cleanStr(str string) string {
-	return strings.ToLower(strings.TrimSpace(str))
+// setCrawledMetadata sets the title and description of the link, unless they were set by the user.
+func setCrawledMetadata(link *db.Link, title, description string) {
+	if !link.CustomTitle {
+		link.Title = title
+	}
+	if !link.CustomDescription {
+		link.Description = description
+	}
 }
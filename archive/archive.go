@@ -0,0 +1,154 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package archive contains the on-disk store for page snapshots.
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"maunium.net/go/lindeb/crawler"
+)
+
+// Config contains the page archive settings.
+type Config struct {
+	// Enabled determines whether snapshots of crawled pages are stored.
+	Enabled bool `yaml:"enabled"`
+	// Path is the directory where the snapshots are stored.
+	Path string `yaml:"path"`
+	// MaxSize is the maximum size of a snapshot in bytes, including inlined images, fonts and stylesheets.
+	MaxSize int64 `yaml:"max_size"`
+	// MaxResourceSize is the maximum size of a single inlined resource in bytes.
+	MaxResourceSize int64 `yaml:"max_resource_size"`
+	// MaxResources is the maximum number of resources fetched for a single snapshot.
+	MaxResources int `yaml:"max_resources"`
+}
+
+// DefaultConfig contains the archive settings used for fields that are not set in the config.
+var DefaultConfig = Config{
+	Path:            "archive",
+	MaxSize:         20 * 1024 * 1024,
+	MaxResourceSize: 5 * 1024 * 1024,
+	MaxResources:    100,
+}
+
+// withDefaults returns a copy of the config where unset fields have their default values.
+func (conf Config) withDefaults() Config {
+	if len(conf.Path) == 0 {
+		conf.Path = DefaultConfig.Path
+	}
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = DefaultConfig.MaxSize
+	}
+	if conf.MaxResourceSize <= 0 {
+		conf.MaxResourceSize = DefaultConfig.MaxResourceSize
+	}
+	if conf.MaxResources <= 0 {
+		conf.MaxResources = DefaultConfig.MaxResources
+	}
+	return conf
+}
+
+// Open opens the store configured in the config. If the archive isn't enabled, nil is returned.
+func (conf Config) Open() (*Store, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	conf = conf.withDefaults()
+	store, err := NewStore(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	store.Limits = crawler.SnapshotLimits{
+		MaxSize:         conf.MaxSize,
+		MaxResourceSize: conf.MaxResourceSize,
+		MaxResources:    conf.MaxResources,
+	}
+	return store, nil
+}
+
+// ErrInvalidHash is returned by Open if the given string is not a SHA-256 hash.
+var ErrInvalidHash = errors.New("invalid blob hash")
+
+// Store is a content-addressed blob store on disk. Each blob is stored in a file named after the SHA-256 hash of its
+// content, so identical snapshots are only stored once. Blobs are never deleted, as they may be shared by many links.
+type Store struct {
+	Path string
+	// Limits are the limits of the snapshots stored in the archive.
+	Limits crawler.SnapshotLimits
+}
+
+// NewStore opens the blob store in the given directory and creates the directory if it doesn't exist.
+func NewStore(path string) (*Store, error) {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Path: path}, nil
+}
+
+// blobPath gets the path of the file of the blob with the given hash. The files are split into subdirectories by the
+// first two characters of the hash to keep the directories small.
+func (store *Store) blobPath(hash string) string {
+	return filepath.Join(store.Path, hash[:2], hash)
+}
+
+// Put stores the given data and returns the hex-encoded SHA-256 hash of it.
+func (store *Store) Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := store.blobPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", err
+	}
+	// The data is written into a temporary file first so that a partially written blob is never visible.
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return hash, nil
+}
+
+// Open opens the blob with the given hash for reading.
+func (store *Store) Open(hash string) (*os.File, error) {
+	if len(hash) != sha256.Size*2 {
+		return nil, ErrInvalidHash
+	} else if _, err := hex.DecodeString(hash); err != nil {
+		return nil, ErrInvalidHash
+	}
+	return os.Open(store.blobPath(hash))
+}
@@ -0,0 +1,160 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"maunium.net/go/lindeb/api"
+	"maunium.net/go/lindeb/crawler"
+	"maunium.net/go/lindeb/db"
+)
+
+// runCommand runs the command given on the command line and returns the exit code.
+func runCommand(config *Config, database *db.DB, args []string) int {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(database, args[1:])
+	case "reindex":
+		return reindexCommand(config, database)
+	default:
+		fmt.Printf("Unknown command %s\n", args[0])
+		return 2
+	}
+}
+
+// migrateCommand is the handler for `lindeb migrate <status|up|down> [version]`.
+func migrateCommand(database *db.DB, args []string) int {
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+
+	version, err := database.SchemaVersion()
+	if err != nil {
+		fmt.Println("Failed to get schema version:", err)
+		return 13
+	}
+
+	var target int
+	switch args[0] {
+	case "status":
+		return migrationStatus(database, version)
+	case "up":
+		target = db.LatestVersion
+	case "down":
+		target = version - 1
+	default:
+		fmt.Printf("Unknown migrate command %s. Available commands: status, up, down\n", args[0])
+		return 2
+	}
+
+	if len(args) > 1 {
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Printf("Invalid target version %s\n", args[1])
+			return 2
+		}
+	}
+	if (args[0] == "up" && target < version) || (args[0] == "down" && target > version) {
+		fmt.Printf("Can't migrate %s from v%d to v%d\n", args[0], version, target)
+		return 2
+	}
+
+	err = database.MigrateTo(target)
+	if err != nil {
+		fmt.Println("Migration failed:", err)
+		return 13
+	}
+	fmt.Printf("Database schema is now at v%d.\n", target)
+	return 0
+}
+
+func migrationStatus(database *db.DB, version int) int {
+	applied, err := database.AppliedMigrations()
+	if err != nil {
+		fmt.Println("Failed to get applied migrations:", err)
+		return 13
+	}
+	appliedAt := make(map[int]int64)
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.Timestamp
+	}
+
+	fmt.Printf("Database schema is at v%d, latest version is v%d.\n", version, db.LatestVersion)
+	for index, migration := range db.Migrations {
+		status := "pending"
+		if ts, ok := appliedAt[index+1]; ok {
+			status = "applied " + time.Unix(ts, 0).Format(time.RFC3339)
+		}
+		fmt.Printf("v%-3d %-40s %s\n", index+1, migration.Description, status)
+	}
+	if version > db.LatestVersion {
+		fmt.Println("The database has been migrated by a newer version of lindeb.")
+	}
+	return 0
+}
+
+// reindexCommand is the handler for `lindeb reindex [--user N] [--recrawl]`.
+func reindexCommand(config *Config, database *db.DB) int {
+	version, err := database.SchemaVersion()
+	if err != nil {
+		fmt.Println("Failed to get schema version:", err)
+		return 13
+	} else if version != db.LatestVersion {
+		fmt.Printf("Database schema is at v%d, but v%d is required. Run `lindeb migrate up` first.\n",
+			version, db.LatestVersion)
+		return 13
+	}
+
+	var user *db.User
+	if *reindexUser != 0 {
+		user = database.GetUser(*reindexUser)
+		if user == nil {
+			fmt.Printf("User #%d not found\n", *reindexUser)
+			return 2
+		}
+	}
+
+	fetcher, err := crawler.NewFetcher(config.Crawler)
+	if err != nil {
+		fmt.Println("Invalid crawler config:", err)
+		return 10
+	}
+
+	index, err := config.Search.Connect()
+	if err != nil {
+		fmt.Println("Failed to open search index:", err)
+		return 12
+	}
+	defer index.Close()
+
+	lindebAPI := api.Create(database, index, config.Queue, fetcher)
+	err = lindebAPI.Reindex(user, *reindexRecrawl, func(done, total int) {
+		if done%100 == 0 || done == total {
+			fmt.Printf("\rReindexed %d/%d links", done, total)
+		}
+	})
+	fmt.Println()
+	if err != nil {
+		fmt.Println("Reindex failed:", err)
+		return 12
+	}
+	fmt.Println("Search index rebuilt successfully.")
+	return 0
+}
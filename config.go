@@ -17,114 +17,58 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
 
 	"github.com/go-yaml/yaml"
+	"maunium.net/go/lindeb/api"
+	"maunium.net/go/lindeb/archive"
+	"maunium.net/go/lindeb/crawler"
 	"maunium.net/go/lindeb/db"
+	"maunium.net/go/lindeb/search"
 
 	"github.com/gorilla/mux"
-	"github.com/olivere/elastic"
 )
 
 // Config is a configuration
 type Config struct {
-	Database db.Config      `yaml:"database"`
-	Elastic  ElasticConfig  `yaml:"elastic"`
-	API      APIConfig      `yaml:"api"`
-	Frontend FrontendConfig `yaml:"frontend"`
+	Database   db.Config             `yaml:"database"`
+	Search     SearchConfig          `yaml:"search"`
+	Queue      api.QueueConfig       `yaml:"queue"`
+	Crawler    crawler.Config        `yaml:"crawler"`
+	Health     api.HealthCheckConfig `yaml:"health_check"`
+	Archive    archive.Config        `yaml:"archive"`
+	Thumbnails api.ThumbnailConfig   `yaml:"thumbnails"`
+	API        APIConfig             `yaml:"api"`
+	Frontend   FrontendConfig        `yaml:"frontend"`
+
+	// Deprecated: Elastic is the Elasticsearch URL from before search drivers were configurable.
+	// It is only used if search.driver is not set.
+	Elastic string `yaml:"elastic"`
 }
 
-// ElasticConfig contains the Elasticsearch server address.
-type ElasticConfig string
-
-const elasticMapping = `
-{
-	"settings": {
-		"number_of_shards": 1,
-		"number_of_replicas": 0,
-		"analysis": {
-			"analyzer": {
-				"html_analyzer": {
-					"tokenizer": "standard",
-					"char_filter": ["html_strip"]
-				},
-				"tag_analyzer": {
-					"tokenizer": "keyword"
-				}
-			}
-		}
-	},
-	"mappings": {
-		"link": {
-			"_routing": {
-				"required": true
-			},
-			"properties": {
-				"id": {
-					"type": "integer",
-					"store": true
-				},
-				"owner": {
-					"type": "integer",
-					"store": true
-				},
-				"url": {
-					"type": "text"
-				},
-				"domain": {
-					"type": "text"
-				},
-				"tags": {
-					"type": "text",
-					"analyzer": "tag_analyzer"
-				},
-				"title": {
-					"type": "text"
-				},
-				"description": {
-					"type": "text"
-				},
-				"html": {
-					"type": "text",
-					"analyzer": "html_analyzer"
-				}
-			}
-		}
-	}
-}`
-
-// Connect connects to the Elasticsearch server.
-func (eConf ElasticConfig) Connect() (*elastic.Client, error) {
-	client, err := elastic.NewClient(
-		elastic.SetURL(string(eConf)),
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	version, err := client.ElasticsearchVersion(string(eConf))
-	if err != nil {
-		return nil, err
-	}
+// SearchConfig contains the search index settings.
+type SearchConfig struct {
+	// Driver is the search index driver to use, either "elastic" or "embedded".
+	Driver string `yaml:"driver"`
+	// URL is the address of the Elasticsearch server for the elastic driver.
+	URL string `yaml:"url"`
+	// Path is the location of the index file for the embedded driver.
+	Path string `yaml:"path"`
+}
 
-	ctx := context.Background()
-	exists, err := client.IndexExists("lindeb").Do(ctx)
-	if err != nil {
-		return client, err
+// Connect opens the search index.
+func (sConf SearchConfig) Connect() (search.Index, error) {
+	switch sConf.Driver {
+	case "elastic", "elasticsearch":
+		return search.NewElastic(sConf.URL)
+	case "embedded":
+		return search.NewEmbedded(sConf.Path)
+	default:
+		return nil, fmt.Errorf("unknown search driver %s", sConf.Driver)
 	}
-	if !exists {
-		_, err := client.CreateIndex("lindeb").BodyString(elasticMapping).Do(ctx)
-		if err != nil {
-			return client, err
-		}
-	}
-
-	fmt.Printf("Connected to Elasticsearch v%s.\n", version)
-	return client, nil
 }
 
 // FrontendConfig contains information on how to serve static frontend files.
@@ -164,6 +108,9 @@ type APIConfig struct {
 	TLS     bool   `yaml:"tls"`
 	TLSCert string `yaml:"tls_cert"`
 	TLSKey  string `yaml:"tls_key"`
+
+	// Admins is the list of usernames that can use the /admin endpoints.
+	Admins []string `yaml:"admins"`
 }
 
 // ListenAndServe sets up a HTTP server according to this API configuration and sets the given
@@ -183,5 +130,8 @@ func LoadConfig(file string) (*Config, error) {
 	}
 	config := &Config{}
 	err = yaml.Unmarshal(rawConfig, config)
+	if len(config.Search.Driver) == 0 && len(config.Elastic) > 0 {
+		config.Search = SearchConfig{Driver: "elastic", URL: config.Elastic}
+	}
 	return config, err
 }
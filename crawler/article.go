@@ -0,0 +1,507 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Article is the readable content of a web page.
+type Article struct {
+	// Text is the main text of the page without navigation, comments and other clutter. Paragraphs are separated with
+	// empty lines.
+	Text string
+	// Byline is the author of the article as shown on the page, if found. Authors in meta tags are in Metadata.
+	Byline string
+	// Published is the time in the first <time> element of the page, or the zero time if there is none.
+	Published time.Time
+	// Language is the lowercase ISO 639 code of the language of the page without the region, e.g. "en".
+	Language  string
+	WordCount int
+}
+
+// maxBylineLength is the maximum length of a byline in bytes. Longer bylines are most likely not bylines at all.
+const maxBylineLength = 255
+
+var (
+	unlikelyCandidates = regexp.MustCompile(`(?i)-ad-|banner|breadcrumbs|combx|comment|community|cover-wrap|disqus|` +
+		`extra|footer|gdpr|header|legends|menu|related|remark|replies|rss|shoutbox|sidebar|skyscraper|social|sponsor|` +
+		`supplemental|ad-break|agegate|pagination|pager|popup|yom-remote`)
+	maybeCandidate    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveClassName = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|pagination|post|` +
+		`text|blog|story`)
+	negativeClassName = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|` +
+		`contact|foot|footer|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|` +
+		`skyscraper|sponsor|shopping|tags|tool|widget`)
+	bylineClassName = regexp.MustCompile(`(?i)byline|author|dateline|writtenby|p-author`)
+)
+
+// removedElements are never part of the readable content.
+var removedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true, atom.Svg: true, atom.Math: true,
+	atom.Button: true, atom.Input: true, atom.Select: true, atom.Textarea: true, atom.Template: true,
+	atom.Object: true, atom.Embed: true, atom.Canvas: true, atom.Nav: true, atom.Header: true, atom.Footer: true,
+	atom.Aside: true, atom.Link: true, atom.Meta: true, atom.Head: true,
+}
+
+// blockElements start a new paragraph in the extracted text.
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Article: true, atom.Section: true, atom.Main: true, atom.Blockquote: true,
+	atom.Pre: true, atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Table: true, atom.Tr: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true,
+	atom.H6: true, atom.Figure: true, atom.Figcaption: true, atom.Br: true, atom.Hr: true, atom.Address: true,
+}
+
+// publishedTimeLayouts are the time formats tried when parsing publish dates from metadata.
+var publishedTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// ExtractArticle finds the main text and the article metadata of a HTML page. The content is found like Mozilla's
+// Readability does it: paragraphs give points to their parents based on the amount of text in them, and the element
+// with the most points after penalizing links is picked as the article.
+//
+// If the page has no recognizable article, all the visible text of the body is used.
+func ExtractArticle(body string) *Article {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return &Article{}
+	}
+	article := &Article{}
+	findArticleMetadata(doc, article)
+
+	bodyNode := findElement(doc, atom.Body)
+	if bodyNode == nil {
+		bodyNode = doc
+	}
+	removeClutter(bodyNode)
+
+	var text string
+	if top := findTopCandidate(bodyNode); top != nil {
+		text = extractText(top.articleNodes())
+	}
+	if len(text) == 0 {
+		text = extractText([]*html.Node{bodyNode})
+	}
+	article.Text = text
+	article.WordCount = len(strings.Fields(text))
+	return article
+}
+
+func getAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func findElement(node *html.Node, element atom.Atom) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == element {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, element); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// normalizeLanguage converts a language tag like en-US or en_GB into a lowercase language code without the region.
+// If the tag doesn't look like a language tag, an empty string is returned.
+func normalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if index := strings.IndexAny(tag, "-_"); index >= 0 {
+		tag = tag[:index]
+	}
+	if len(tag) < 2 || len(tag) > 3 {
+		return ""
+	}
+	for _, char := range tag {
+		if char < 'a' || char > 'z' {
+			return ""
+		}
+	}
+	return tag
+}
+
+func parsePublishedTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range publishedTimeLayouts {
+		parsed, err := time.Parse(layout, value)
+		if err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func collapseWhitespace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// findArticleMetadata finds the language, byline and publish date of the page. The byline and date are read from the
+// page content, so they're only useful if the page doesn't have them in its metadata.
+func findArticleMetadata(doc *html.Node, article *Article) {
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.DataAtom {
+			case atom.Html:
+				if len(article.Language) == 0 {
+					article.Language = normalizeLanguage(getAttr(node, "lang"))
+				}
+			case atom.Meta:
+				findMetaLanguage(node, article)
+			case atom.Time:
+				if article.Published.IsZero() {
+					article.Published, _ = parsePublishedTime(getAttr(node, "datetime"))
+				}
+			case atom.Script, atom.Style:
+				return
+			default:
+				if len(article.Byline) == 0 && isByline(node) {
+					article.Byline = collapseWhitespace(textContent(node))
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(doc)
+	if len(article.Byline) > 3 && strings.EqualFold(article.Byline[:3], "by ") {
+		article.Byline = strings.TrimSpace(article.Byline[3:])
+	}
+	if len(article.Byline) > maxBylineLength {
+		article.Byline = truncateString(article.Byline, maxBylineLength)
+	}
+}
+
+// findMetaLanguage finds the language of the page from a meta tag.
+func findMetaLanguage(node *html.Node, article *Article) {
+	content := getAttr(node, "content")
+	name := strings.ToLower(getAttr(node, "property"))
+	if name == "og:locale" && len(article.Language) == 0 {
+		article.Language = normalizeLanguage(content)
+	}
+	if strings.EqualFold(getAttr(node, "http-equiv"), "content-language") && len(article.Language) == 0 {
+		article.Language = normalizeLanguage(content)
+	}
+}
+
+// isByline checks if the given element is a short element marked as the author of the page.
+func isByline(node *html.Node) bool {
+	if getAttr(node, "rel") != "author" && !strings.Contains(getAttr(node, "itemprop"), "author") &&
+		!bylineClassName.MatchString(getAttr(node, "class")+" "+getAttr(node, "id")) {
+		return false
+	}
+	length := len(strings.TrimSpace(textContent(node)))
+	return length > 0 && length < 100
+}
+
+func truncateString(str string, maxBytes int) string {
+	if len(str) <= maxBytes {
+		return str
+	}
+	str = str[:maxBytes]
+	for len(str) > 0 && !utf8.ValidString(str) {
+		str = str[:len(str)-1]
+	}
+	return str
+}
+
+func textContent(node *html.Node) string {
+	var buf strings.Builder
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			buf.WriteString(node.Data)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(node)
+	return buf.String()
+}
+
+func isHidden(node *html.Node) bool {
+	style := strings.ToLower(strings.Replace(getAttr(node, "style"), " ", "", -1))
+	for _, attr := range node.Attr {
+		if attr.Key == "hidden" {
+			return true
+		}
+	}
+	return getAttr(node, "aria-hidden") == "true" || strings.Contains(style, "display:none") ||
+		strings.Contains(style, "visibility:hidden")
+}
+
+// removeClutter removes the elements that can't be part of the article, like scripts, navigation and comments.
+func removeClutter(node *html.Node) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		switch child.Type {
+		case html.ElementNode:
+			classAndID := getAttr(child, "class") + " " + getAttr(child, "id")
+			unlikely := unlikelyCandidates.MatchString(classAndID) && !maybeCandidate.MatchString(classAndID) &&
+				child.DataAtom != atom.Body && child.DataAtom != atom.Article && child.DataAtom != atom.A
+			if removedElements[child.DataAtom] || unlikely || isHidden(child) {
+				node.RemoveChild(child)
+			} else {
+				removeClutter(child)
+			}
+		case html.CommentNode:
+			node.RemoveChild(child)
+		}
+		child = next
+	}
+}
+
+// classWeight gives points to elements whose class or ID suggest that they contain the article, and removes points
+// from elements that seem to contain something else.
+func classWeight(node *html.Node) float64 {
+	var weight float64
+	for _, value := range []string{getAttr(node, "class"), getAttr(node, "id")} {
+		if len(value) == 0 {
+			continue
+		}
+		if negativeClassName.MatchString(value) {
+			weight -= 25
+		}
+		if positiveClassName.MatchString(value) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+func tagWeight(node *html.Node) float64 {
+	switch node.DataAtom {
+	case atom.Article:
+		return 10
+	case atom.Div, atom.Main, atom.Section:
+		return 5
+	case atom.Pre, atom.Td, atom.Blockquote:
+		return 3
+	case atom.Address, atom.Ol, atom.Ul, atom.Dl, atom.Dd, atom.Dt, atom.Li, atom.Form:
+		return -3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		return -5
+	default:
+		return 0
+	}
+}
+
+// linkDensity is the share of the text of the node that is inside links.
+func linkDensity(node *html.Node) float64 {
+	textLength := len(strings.TrimSpace(textContent(node)))
+	if textLength == 0 {
+		return 0
+	}
+	var linkLength int
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.DataAtom == atom.A {
+			linkLength += len(strings.TrimSpace(textContent(node)))
+			return
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(node)
+	return float64(linkLength) / float64(textLength)
+}
+
+// hasBlockChildren checks if the given element contains other paragraph-like elements. Divs without any are scored
+// like paragraphs, as many sites don't use <p> tags.
+func hasBlockChildren(node *html.Node) bool {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && (blockElements[child.DataAtom] || hasBlockChildren(child)) &&
+			child.DataAtom != atom.Br {
+			return true
+		}
+	}
+	return false
+}
+
+type candidate struct {
+	node  *html.Node
+	score float64
+	// siblings contains the siblings of the node that seem to be part of the same article.
+	siblings []*html.Node
+}
+
+// articleNodes returns the candidate and the siblings included in the article in document order.
+func (cand *candidate) articleNodes() []*html.Node {
+	if len(cand.siblings) == 0 {
+		return []*html.Node{cand.node}
+	}
+	return cand.siblings
+}
+
+// findTopCandidate scores the elements of the page and returns the one that most likely contains the article. If no
+// element contains enough text, nil is returned.
+func findTopCandidate(root *html.Node) *candidate {
+	candidates := make(map[*html.Node]*candidate)
+	getCandidate := func(node *html.Node) *candidate {
+		cand, ok := candidates[node]
+		if !ok {
+			cand = &candidate{node: node, score: tagWeight(node) + classWeight(node)}
+			candidates[node] = cand
+		}
+		return cand
+	}
+
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type != html.ElementNode {
+			return
+		}
+		isParagraph := node.DataAtom == atom.P || node.DataAtom == atom.Pre || node.DataAtom == atom.Td ||
+			(node.DataAtom == atom.Div && !hasBlockChildren(node))
+		if !isParagraph {
+			for child := node.FirstChild; child != nil; child = child.NextSibling {
+				visit(child)
+			}
+			return
+		}
+
+		text := strings.TrimSpace(textContent(node))
+		if len(text) < 25 || node.Parent == nil {
+			return
+		}
+		lengthBonus := len(text) / 100
+		if lengthBonus > 3 {
+			lengthBonus = 3
+		}
+		score := 1 + float64(strings.Count(text, ",")) + float64(lengthBonus)
+		getCandidate(node.Parent).score += score
+		if grandparent := node.Parent.Parent; grandparent != nil && grandparent.Type == html.ElementNode {
+			getCandidate(grandparent).score += score / 2
+		}
+	}
+	visit(root)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sorted := make([]*candidate, 0, len(candidates))
+	for _, cand := range candidates {
+		cand.score *= 1 - linkDensity(cand.node)
+		sorted = append(sorted, cand)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].score > sorted[j].score
+	})
+	top := sorted[0]
+	if top.score <= 0 {
+		return nil
+	}
+
+	// Articles split into multiple sibling elements are joined by including siblings that scored well too.
+	threshold := top.score * 0.2
+	if threshold < 10 {
+		threshold = 10
+	}
+	if top.node.Parent != nil {
+		for sibling := top.node.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+			if sibling == top.node {
+				top.siblings = append(top.siblings, sibling)
+			} else if cand, ok := candidates[sibling]; ok && cand.score >= threshold {
+				top.siblings = append(top.siblings, sibling)
+			}
+		}
+	}
+	return top
+}
+
+// extractText converts the given elements into plain text. Lists and other blocks inside the elements that are mostly
+// links or marked as not being content are skipped.
+func extractText(nodes []*html.Node) string {
+	var paragraphs []string
+	var current strings.Builder
+	endParagraph := func() {
+		if text := collapseWhitespace(current.String()); len(text) > 0 {
+			paragraphs = append(paragraphs, text)
+		}
+		current.Reset()
+	}
+
+	var visit func(node *html.Node, nested bool)
+	visit = func(node *html.Node, nested bool) {
+		switch node.Type {
+		case html.TextNode:
+			current.WriteString(node.Data)
+			return
+		case html.ElementNode:
+			if nested && isLowQualityBlock(node) {
+				return
+			}
+		}
+		block := node.Type == html.ElementNode && blockElements[node.DataAtom]
+		if block {
+			endParagraph()
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child, true)
+		}
+		if block {
+			endParagraph()
+		} else if node.DataAtom == atom.Td || node.DataAtom == atom.Th {
+			// Table cells would otherwise be glued together.
+			current.WriteByte(' ')
+		}
+	}
+	for _, node := range nodes {
+		visit(node, false)
+	}
+	endParagraph()
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// isLowQualityBlock checks if the given element is a container that probably isn't part of the article, such as a
+// list of share links inside the article.
+func isLowQualityBlock(node *html.Node) bool {
+	switch node.DataAtom {
+	case atom.Div, atom.Section, atom.Ul, atom.Ol, atom.Table:
+	default:
+		return false
+	}
+	if classWeight(node) < 0 {
+		return true
+	}
+	text := strings.TrimSpace(textContent(node))
+	return len(text) < 200 && len(text) > 0 && linkDensity(node) > 0.5
+}
@@ -0,0 +1,72 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"net/http"
+)
+
+// CheckResult is the response to a request made by Check.
+type CheckResult struct {
+	StatusCode int
+	// URL is the final URL after following redirects.
+	URL string
+}
+
+// Check requests the given URL to find out if it still works, without downloading the page. A HEAD request is sent
+// first, and if the server responds to it with an error, a GET request is sent, as some servers don't support HEAD.
+//
+// The same host limits and robots.txt rules apply as with Fetch. A HTTPError is returned along with the result if the
+// server responds with an error status code.
+func (fetcher *Fetcher) Check(url string) (*CheckResult, error) {
+	resp, err := fetcher.checkRequest(http.MethodHead, url)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode >= 400 && resp.StatusCode != http.StatusTooManyRequests {
+		resp, err = fetcher.checkRequest(http.MethodGet, url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result := &CheckResult{
+		StatusCode: resp.StatusCode,
+		URL:        resp.Request.URL.String(),
+	}
+	if resp.StatusCode >= 400 {
+		return result, fetcher.httpError(resp)
+	}
+	return result, nil
+}
+
+// checkRequest sends a request with the given method and closes the response body without reading it.
+func (fetcher *Fetcher) checkRequest(method, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	release, err := fetcher.startRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	resp, err := fetcher.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,354 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package crawler contains the HTTP client used to fetch the pages of saved links.
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// Config contains the settings of the page fetcher.
+type Config struct {
+	// Timeout is the maximum number of seconds a whole request can take, including reading the body.
+	Timeout int `yaml:"timeout"`
+	// ConnectTimeout is the maximum number of seconds to wait for a connection to be established.
+	ConnectTimeout int `yaml:"connect_timeout"`
+	// MaxBodySize is the maximum number of bytes read from a response. Longer bodies are truncated.
+	MaxBodySize int64 `yaml:"max_body_size"`
+	// MaxRedirects is the maximum number of redirects to follow.
+	MaxRedirects int `yaml:"max_redirects"`
+	// UserAgent is sent in the User-Agent header of requests.
+	UserAgent string `yaml:"user_agent"`
+	// AllowedContentTypes contains the media types of the responses that are read. See Page.Extract for the types
+	// whose content can be extracted.
+	AllowedContentTypes []string `yaml:"allowed_content_types"`
+	// BlockedNetworks contains CIDR ranges that can't be fetched in addition to the loopback, private, link-local and
+	// other non-public addresses that are always blocked.
+	BlockedNetworks []string `yaml:"blocked_networks"`
+	// AllowedNetworks contains CIDR ranges that can be fetched even if they're blocked.
+	AllowedNetworks []string `yaml:"allowed_networks"`
+	// HostConcurrency is the maximum number of requests to a single host at the same time.
+	HostConcurrency int `yaml:"host_concurrency"`
+	// HostDelay is the minimum number of seconds between starting requests to a single host. A longer Crawl-delay in
+	// the robots.txt file of the host is honored.
+	HostDelay float64 `yaml:"host_delay"`
+	// MaxHostWait is the maximum number of seconds to wait for the limits of a host before giving up with a
+	// HostBusyError.
+	MaxHostWait int `yaml:"max_host_wait"`
+	// IgnoreRobotsTxt disables checking the robots.txt files of websites before fetching pages.
+	IgnoreRobotsTxt bool `yaml:"ignore_robots_txt"`
+	// RobotsTxtCacheTime is the number of seconds robots.txt files are cached.
+	RobotsTxtCacheTime int `yaml:"robots_txt_cache_time"`
+}
+
+// DefaultConfig contains the fetcher settings used for fields that are not set in the config.
+var DefaultConfig = Config{
+	Timeout:        15,
+	ConnectTimeout: 5,
+	MaxBodySize:    5 * 1024 * 1024,
+	MaxRedirects:   5,
+	UserAgent:      "lindeb (+https://github.com/tulir/lindeb)",
+	AllowedContentTypes: []string{
+		"text/html", "application/xhtml+xml", "application/pdf", "text/plain", "text/markdown", "text/x-markdown",
+	},
+	HostConcurrency:    2,
+	HostDelay:          1,
+	MaxHostWait:        10,
+	RobotsTxtCacheTime: 24 * 60 * 60,
+}
+
+// withDefaults returns a copy of the config where unset fields have their default values.
+func (conf Config) withDefaults() Config {
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultConfig.Timeout
+	}
+	if conf.ConnectTimeout <= 0 {
+		conf.ConnectTimeout = DefaultConfig.ConnectTimeout
+	}
+	if conf.MaxBodySize <= 0 {
+		conf.MaxBodySize = DefaultConfig.MaxBodySize
+	}
+	if conf.MaxRedirects <= 0 {
+		conf.MaxRedirects = DefaultConfig.MaxRedirects
+	}
+	if len(conf.UserAgent) == 0 {
+		conf.UserAgent = DefaultConfig.UserAgent
+	}
+	if len(conf.AllowedContentTypes) == 0 {
+		conf.AllowedContentTypes = DefaultConfig.AllowedContentTypes
+	}
+	if conf.HostConcurrency <= 0 {
+		conf.HostConcurrency = DefaultConfig.HostConcurrency
+	}
+	if conf.HostDelay <= 0 {
+		conf.HostDelay = DefaultConfig.HostDelay
+	}
+	if conf.MaxHostWait <= 0 {
+		conf.MaxHostWait = DefaultConfig.MaxHostWait
+	}
+	if conf.RobotsTxtCacheTime <= 0 {
+		conf.RobotsTxtCacheTime = DefaultConfig.RobotsTxtCacheTime
+	}
+	return conf
+}
+
+// ErrTooManyRedirects is returned by Fetch if the page redirects more times than the config allows.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// UnsupportedContentTypeError is returned by Fetch if the content type of the response is not allowed by the config.
+type UnsupportedContentTypeError struct {
+	ContentType string
+}
+
+func (err UnsupportedContentTypeError) Error() string {
+	return fmt.Sprintf("unsupported content type %q", err.ContentType)
+}
+
+// HTTPError is returned by Fetch if the server responds with an error status code.
+type HTTPError struct {
+	StatusCode int
+	// RetryAfter is how long the server asked to wait before trying again. It's only set for 429 and 503 responses.
+	RetryAfter time.Duration
+}
+
+func (err HTTPError) Error() string {
+	return fmt.Sprintf("server responded with HTTP %d", err.StatusCode)
+}
+
+// Page is a fetched web page.
+type Page struct {
+	// URL is the final URL of the page after following redirects.
+	URL        string
+	StatusCode int
+	// ContentType is the media type of the page without parameters.
+	ContentType string
+	// Charset is the charset parameter of the Content-Type header, if any. Use Text to get the body decoded with the
+	// charset.
+	Charset string
+	Body    []byte
+	// Truncated is true if the body was longer than the maximum size in the config.
+	Truncated bool
+}
+
+// Text decodes the body of the page into UTF-8. The charset is detected like browsers do it: a byte order mark is
+// preferred over the Content-Type header, which is preferred over <meta> tags in the beginning of the page. Pages that
+// don't specify a charset are decoded as UTF-8 if they're valid UTF-8 and as Windows-1252 otherwise.
+func (page *Page) Text() string {
+	contentType := page.ContentType
+	if len(page.Charset) > 0 {
+		contentType = mime.FormatMediaType(page.ContentType, map[string]string{"charset": page.Charset})
+	}
+	enc, name, certain := charset.DetermineEncoding(page.Body, contentType)
+	// The detection only looks at the first kilobyte, so pages with no non-ASCII characters in the beginning would be
+	// detected as Windows-1252.
+	if !certain && name == "windows-1252" && utf8.Valid(page.Body) {
+		enc = encoding.Nop
+	}
+	text, err := enc.NewDecoder().Bytes(page.Body)
+	if err != nil {
+		return string(page.Body)
+	}
+	return string(text)
+}
+
+// Fetcher fetches web pages with the limits in its config. The number of requests to each host is limited and the
+// robots.txt files of websites are honored.
+type Fetcher struct {
+	Config    Config
+	Client    *http.Client
+	filter    *addressFilter
+	limiter   *hostLimiter
+	robots    *robotsCache
+	agentName string
+}
+
+// NewFetcher creates a fetcher with the given config. Unset fields in the config have their default values. An error
+// is returned if the network lists in the config are invalid.
+func NewFetcher(conf Config) (*Fetcher, error) {
+	conf = conf.withDefaults()
+	filter, err := newAddressFilter(conf.BlockedNetworks, conf.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	connectTimeout := time.Duration(conf.ConnectTimeout) * time.Second
+	dialer := &net.Dialer{
+		Timeout: connectTimeout,
+		// The address is checked after DNS resolution, so that hostnames pointing to blocked addresses are caught as
+		// well. Redirects go through the same dialer.
+		Control: filter.control,
+	}
+	transport := &http.Transport{
+		// Proxies are not used, as the addresses they connect to can't be checked.
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: time.Duration(conf.Timeout) * time.Second,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	fetcher := &Fetcher{
+		Config:    conf,
+		filter:    filter,
+		limiter:   newHostLimiter(conf),
+		robots:    &robotsCache{entries: make(map[string]*robotsEntry)},
+		agentName: robotsAgentName(conf.UserAgent),
+	}
+	fetcher.Client = &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(conf.Timeout) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > conf.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			// Redirects are not counted in the host limits, but the robots.txt of the target must allow them. The
+			// redirects of robots.txt files themselves are not checked, as that would fetch robots.txt files forever.
+			if via[0].URL.EscapedPath() == "/robots.txt" {
+				return nil
+			}
+			return fetcher.checkRobots(req.URL)
+		},
+	}
+	return fetcher, nil
+}
+
+// Fetch gets the page at the given URL. An error is returned if the request fails, the server responds with an error
+// status code or the content type of the response is not allowed.
+//
+// Fetch waits if the host of the page has too many requests running or was requested too recently. A HostBusyError
+// is returned if the wait would be longer than the config allows. A RobotsDisallowedError is returned if the
+// robots.txt file of the website disallows fetching the page.
+func (fetcher *Fetcher) Fetch(url string) (*Page, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	release, err := fetcher.startRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	req.Header.Set("Accept", strings.Join(fetcher.Config.AllowedContentTypes, ", ")+", */*;q=0.1")
+
+	resp, err := fetcher.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	page := &Page{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+	}
+	if resp.StatusCode >= 400 {
+		return page, fetcher.httpError(resp)
+	}
+
+	// Responses with a disallowed type are rejected before reading the body. The body is only needed for finding out
+	// the type if the server didn't send it.
+	contentType := resp.Header.Get("Content-Type")
+	if len(contentType) > 0 {
+		err = fetcher.checkContentType(page, contentType)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	// Read one byte more than the limit to find out if the body was truncated.
+	page.Body, err = ioutil.ReadAll(io.LimitReader(resp.Body, fetcher.Config.MaxBodySize+1))
+	if err != nil {
+		return page, err
+	}
+	if int64(len(page.Body)) > fetcher.Config.MaxBodySize {
+		page.Body = page.Body[:fetcher.Config.MaxBodySize]
+		page.Truncated = true
+	}
+
+	if len(contentType) == 0 {
+		err = fetcher.checkContentType(page, http.DetectContentType(page.Body))
+		if err != nil {
+			page.Body = nil
+			return page, err
+		}
+	}
+	return page, nil
+}
+
+// startRequest waits until the host of the request can be requested and checks that robots.txt allows the request.
+// The User-Agent header is set on the request. The returned function must be called after the request is done.
+//
+// If delayed is false, the minimum time between requests to the host is not applied. It's meant for page resources.
+func (fetcher *Fetcher) startRequest(req *http.Request, delayed bool) (release func(), err error) {
+	host := strings.ToLower(req.URL.Host)
+	err = fetcher.limiter.acquire(host, delayed)
+	if err != nil {
+		return nil, err
+	}
+	err = fetcher.checkRobots(req.URL)
+	if err != nil {
+		fetcher.limiter.release(host)
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fetcher.Config.UserAgent)
+	return func() {
+		fetcher.limiter.release(host)
+	}, nil
+}
+
+// httpError creates the error for a response with an error status code. If the server asked to wait with a 429 or 503
+// response, requests to the host are stopped for that time.
+func (fetcher *Fetcher) httpError(resp *http.Response) HTTPError {
+	httpErr := HTTPError{StatusCode: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		httpErr.RetryAfter = fetcher.limiter.backOff(strings.ToLower(resp.Request.URL.Host), resp.StatusCode,
+			resp.Header.Get("Retry-After"))
+	}
+	return httpErr
+}
+
+// checkContentType parses the given Content-Type header into the page and checks if the type is allowed.
+func (fetcher *Fetcher) checkContentType(page *Page, contentType string) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return UnsupportedContentTypeError{contentType}
+	}
+	page.ContentType = mediaType
+	page.Charset = params["charset"]
+	if !fetcher.allowed(mediaType) {
+		return UnsupportedContentTypeError{mediaType}
+	}
+	return nil
+}
+
+func (fetcher *Fetcher) allowed(mediaType string) bool {
+	for _, allowed := range fetcher.Config.AllowedContentTypes {
+		if strings.EqualFold(allowed, mediaType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxTitleLineLength is the maximum length of a line of plain text that is used as the title.
+const maxTitleLineLength = 200
+
+// Extract finds the metadata and readable text of the page with the extractor for its content type. HTML pages,
+// PDFs, plain text and Markdown are supported.
+//
+// An error is returned if the content type has no extractor or the content is malformed. The metadata and article are
+// never nil. Documents other than HTML pages get the file name from the URL as the title if they have no better one.
+func (page *Page) Extract() (meta *Metadata, article *Article, err error) {
+	switch page.ContentType {
+	case "text/html", "application/xhtml+xml":
+		text := page.Text()
+		return ExtractMetadata(text, page.URL), ExtractArticle(text), nil
+	case "application/pdf":
+		if page.Truncated {
+			// The cross-reference table is at the end of the file, so truncated PDFs can't be read.
+			err = fmt.Errorf("PDF is larger than the maximum body size")
+		} else {
+			meta, article, err = extractPDF(page.Body)
+		}
+	case "text/plain":
+		meta, article = extractPlainText(page.Text(), false)
+	case "text/markdown", "text/x-markdown":
+		meta, article = extractPlainText(page.Text(), true)
+	default:
+		err = UnsupportedContentTypeError{page.ContentType}
+	}
+	if meta == nil {
+		meta = &Metadata{}
+	}
+	if article == nil {
+		article = &Article{}
+	}
+	if len(meta.Title) == 0 {
+		meta.Title = fileName(page.URL)
+	}
+	return
+}
+
+// fileName returns the last element of the path of the given URL, or an empty string if the path is empty.
+func fileName(pageURL string) string {
+	parsed, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(parsed.Path)
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// extractPlainText uses the first heading of a Markdown document or the first line of a plain text document as the
+// title. The whole document is used as the text.
+func extractPlainText(text string, markdown bool) (*Metadata, *Article) {
+	meta := &Metadata{}
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	if markdown {
+		meta.Title = markdownHeading(lines)
+	}
+	if len(meta.Title) == 0 {
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if len(line) > 0 {
+				if len(line) <= maxTitleLineLength {
+					meta.Title = strings.TrimSpace(strings.TrimLeft(line, "#"))
+				}
+				break
+			}
+		}
+	}
+	meta.Title = truncateString(meta.Title, maxMetadataLength)
+	article := &Article{Text: strings.TrimSpace(text)}
+	article.WordCount = len(strings.Fields(article.Text))
+	return meta, article
+}
+
+// markdownHeading finds the first ATX (# Heading) or setext (Heading followed by === or ---) heading in the given
+// lines of Markdown.
+func markdownHeading(lines []string) string {
+	inCodeBlock := false
+	// YAML front matter is skipped like a code block.
+	inFrontMatter := len(lines) > 0 && strings.TrimSpace(lines[0]) == "---"
+	for index, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if inFrontMatter {
+			inFrontMatter = index == 0 || trimmed != "---"
+			continue
+		} else if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCodeBlock = !inCodeBlock
+			continue
+		} else if inCodeBlock || len(trimmed) == 0 {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			heading := strings.TrimLeft(trimmed, "#")
+			if len(heading) == 0 || heading[0] == ' ' || heading[0] == '\t' {
+				return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(heading), "#"))
+			}
+		}
+		if index+1 < len(lines) {
+			underline := strings.TrimSpace(lines[index+1])
+			if len(underline) > 0 && (strings.Trim(underline, "=") == "" || strings.Trim(underline, "-") == "") {
+				return trimmed
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+)
+
+// maxICOSize is the maximum width and height of icons in ICO files. The format itself only goes up to 256, but the
+// bitmap headers could claim anything.
+const maxICOSize = 1024
+
+var errInvalidICO = errors.New("invalid ICO file")
+
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+// isICO checks if the data starts with the header of an ICO file.
+func isICO(data []byte) bool {
+	return len(data) >= 6 && bytes.Equal(data[:4], []byte{0, 0, 1, 0})
+}
+
+// decodeICO decodes the largest icon in an ICO file. The icons can be either PNG images or bitmaps without the BMP
+// file header.
+func decodeICO(data []byte) (image.Image, error) {
+	if !isICO(data) {
+		return nil, errInvalidICO
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if len(data) < 6+count*16 {
+		return nil, errInvalidICO
+	}
+	var bestOffset, bestLength, bestSize, bestDepth int
+	for index := 0; index < count; index++ {
+		entry := data[6+index*16:]
+		// A width of zero means 256 pixels.
+		size := int(entry[0])
+		if size == 0 {
+			size = 256
+		}
+		depth := int(binary.LittleEndian.Uint16(entry[6:]))
+		if size > bestSize || (size == bestSize && depth > bestDepth) {
+			bestSize, bestDepth = size, depth
+			bestLength = int(binary.LittleEndian.Uint32(entry[8:]))
+			bestOffset = int(binary.LittleEndian.Uint32(entry[12:]))
+		}
+	}
+	if bestSize == 0 || bestOffset < 0 || bestLength <= 0 || bestOffset+bestLength > len(data) ||
+		bestOffset+bestLength < bestOffset {
+		return nil, errInvalidICO
+	}
+	icon := data[bestOffset : bestOffset+bestLength]
+	if bytes.HasPrefix(icon, pngMagic) {
+		return decodeImage(icon, "image/png")
+	}
+	return decodeDIB(icon)
+}
+
+// decodeDIB decodes an uncompressed bitmap from an ICO file. The height in the header includes the 1-bit transparency
+// mask that comes after the image, and rows are stored from the bottom up. 32-bit bitmaps have an alpha channel, and
+// the transparency mask is only used for them if all the alpha values are zero.
+func decodeDIB(data []byte) (image.Image, error) {
+	if len(data) < 40 {
+		return nil, errInvalidICO
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[0:]))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:])))
+	height := int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2
+	depth := int(binary.LittleEndian.Uint16(data[14:]))
+	compression := binary.LittleEndian.Uint32(data[16:])
+	paletteSize := int(binary.LittleEndian.Uint32(data[32:]))
+	if compression != 0 || headerSize < 40 || headerSize > len(data) ||
+		width <= 0 || height <= 0 || width > maxICOSize || height > maxICOSize {
+		return nil, ErrUnsupportedImage
+	}
+
+	var palette []color.NRGBA
+	switch depth {
+	case 1, 4, 8:
+		if paletteSize == 0 || paletteSize > 1<<uint(depth) {
+			paletteSize = 1 << uint(depth)
+		}
+		if len(data) < headerSize+paletteSize*4 {
+			return nil, errInvalidICO
+		}
+		palette = make([]color.NRGBA, paletteSize)
+		for index := range palette {
+			entry := data[headerSize+index*4:]
+			palette[index] = color.NRGBA{R: entry[2], G: entry[1], B: entry[0], A: 0xff}
+		}
+	case 24, 32:
+		paletteSize = 0
+	default:
+		return nil, ErrUnsupportedImage
+	}
+
+	pixelStart := headerSize + paletteSize*4
+	pixelStride := (width*depth + 31) / 32 * 4
+	maskStart := pixelStart + pixelStride*height
+	maskStride := (width + 31) / 32 * 4
+	if len(data) < maskStart {
+		return nil, errInvalidICO
+	}
+	// Some 32-bit icons leave out the mask, as the alpha channel makes it unnecessary.
+	hasMask := len(data) >= maskStart+maskStride*height
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := data[pixelStart+(height-1-y)*pixelStride:]
+		for x := 0; x < width; x++ {
+			var pixel color.NRGBA
+			switch depth {
+			case 32:
+				pixel = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				hasAlpha = hasAlpha || pixel.A != 0
+			case 24:
+				pixel = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 0xff}
+			default:
+				bit := x * depth
+				index := int(row[bit/8]>>uint(8-depth-bit%8)) & (1<<uint(depth) - 1)
+				if index < len(palette) {
+					pixel = palette[index]
+				}
+			}
+			img.SetNRGBA(x, y, pixel)
+		}
+	}
+	if depth == 32 && hasAlpha || !hasMask {
+		if depth == 32 && !hasAlpha {
+			// Without a mask or alpha values, the icon is fully opaque.
+			for offset := 3; offset < len(img.Pix); offset += 4 {
+				img.Pix[offset] = 0xff
+			}
+		}
+		return img, nil
+	}
+	for y := 0; y < height; y++ {
+		row := data[maskStart+(height-1-y)*maskStride:]
+		for x := 0; x < width; x++ {
+			alpha := uint8(0xff)
+			if row[x/8]&(0x80>>uint(x%8)) != 0 {
+				alpha = 0
+			}
+			img.Pix[img.PixOffset(x, y)+3] = alpha
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,119 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+
+	"golang.org/x/image/draw"
+
+	// Register the decoders of the other image formats used on websites.
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+// maxImagePixels is the maximum number of pixels in images that are decoded. It stops small files from using lots of
+// memory by claiming to be huge images.
+const maxImagePixels = 40 * 1000 * 1000
+
+// thumbnailJPEGQuality is the quality of thumbnails that are encoded as JPEG.
+const thumbnailJPEGQuality = 85
+
+// ErrUnsupportedImage is returned by FetchImage if the image is not in a format that can be decoded.
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// ErrImageTooLarge is returned by FetchImage if the image has more pixels than can be decoded.
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// FaviconURL gets the URL of the favicon of a page. The icon declared on the page is preferred, and /favicon.ico of
+// the website is used if the page doesn't declare one.
+func FaviconURL(pageURL string, meta *Metadata) string {
+	if meta != nil && len(meta.IconURL) > 0 {
+		return meta.IconURL
+	}
+	parsed, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.ResolveReference(&url.URL{Path: "/favicon.ico"}).String()
+}
+
+// FetchImage fetches and decodes the image at the given URL. Images larger than maxSize bytes are not read. PNG, JPEG,
+// GIF, WebP and ICO images are supported.
+func (fetcher *Fetcher) FetchImage(imageURL string, maxSize int64) (image.Image, error) {
+	body, mediaType, err := fetcher.fetchResource(imageURL, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(body, mediaType)
+}
+
+func decodeImage(data []byte, mediaType string) (image.Image, error) {
+	if mediaType == "image/x-icon" || mediaType == "image/vnd.microsoft.icon" || isICO(data) {
+		return decodeICO(data)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupportedImage
+	} else if err != nil {
+		return nil, err
+	} else if config.Width*config.Height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Thumbnail scales the image to the given size. If the aspect ratio of the image is different, the middle of the
+// image is cropped to the right aspect ratio first.
+func Thumbnail(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	crop := bounds
+	// Compare width/height ratios without dividing.
+	if bounds.Dx()*height > bounds.Dy()*width {
+		cropWidth := bounds.Dy() * width / height
+		crop.Min.X += (bounds.Dx() - cropWidth) / 2
+		crop.Max.X = crop.Min.X + cropWidth
+	} else {
+		cropHeight := bounds.Dx() * height / width
+		crop.Min.Y += (bounds.Dy() - cropHeight) / 2
+		crop.Max.Y = crop.Min.Y + cropHeight
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, crop, draw.Src, nil)
+	return thumbnail
+}
+
+// EncodeThumbnail encodes a thumbnail as JPEG, or as PNG if it has transparent parts. The media type of the encoded
+// image is returned along with the data.
+func EncodeThumbnail(thumbnail *image.RGBA) (data []byte, mediaType string, err error) {
+	var buf bytes.Buffer
+	if thumbnail.Opaque() {
+		err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: thumbnailJPEGQuality})
+		mediaType = "image/jpeg"
+	} else {
+		err = png.Encode(&buf, thumbnail)
+		mediaType = "image/png"
+	}
+	return buf.Bytes(), mediaType, err
+}
@@ -0,0 +1,338 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Metadata contains the information a web page gives about itself.
+type Metadata struct {
+	Title       string
+	Description string
+	// CanonicalURL is the preferred URL of the page.
+	CanonicalURL string
+	// ImageURL is the URL of the preview image of the page.
+	ImageURL string
+	// SiteName is the name of the website the page is on.
+	SiteName string
+	// IconURL is the URL of the favicon declared on the page. It's empty if the page doesn't declare one, in which
+	// case browsers use /favicon.ico.
+	IconURL string
+	Author  string
+	// Published is the time the page was published, or the zero time if it's not known.
+	Published time.Time
+}
+
+// maxMetadataLength is the maximum length of the title, site name and author in bytes.
+const maxMetadataLength = 255
+
+// jsonLDTypes are the JSON-LD object types whose data is used for the metadata.
+var jsonLDTypes = map[string]bool{
+	"Article": true, "NewsArticle": true, "BlogPosting": true, "TechArticle": true, "ScholarlyArticle": true,
+	"Report": true, "Product": true, "VideoObject": true,
+}
+
+// pageMetadata collects the values found from each metadata source, so that the preferred source can be picked
+// after the whole page has been read.
+type pageMetadata struct {
+	html, openGraph, twitter, jsonLD Metadata
+	// canonical is the URL in <link rel="canonical">, which is preferred over the URLs of all other sources.
+	canonical string
+	// icon is the best favicon found so far and iconSize is its declared size.
+	icon     string
+	iconSize int
+}
+
+// ExtractMetadata reads the OpenGraph, Twitter card, JSON-LD and plain HTML metadata of a page. OpenGraph is preferred,
+// then JSON-LD, then Twitter cards and finally the standard HTML tags. Relative URLs are resolved against pageURL.
+func ExtractMetadata(body, pageURL string) *Metadata {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return &Metadata{}
+	}
+	var page pageMetadata
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.DataAtom {
+			case atom.Title:
+				if len(page.html.Title) == 0 {
+					page.html.Title = textContent(node)
+				}
+			case atom.Meta:
+				page.readMeta(node)
+			case atom.Link:
+				if hasToken(getAttr(node, "rel"), "canonical") && len(page.canonical) == 0 {
+					page.canonical = getAttr(node, "href")
+				}
+				page.readIcon(node)
+			case atom.Script:
+				if strings.EqualFold(strings.TrimSpace(getAttr(node, "type")), "application/ld+json") {
+					page.readJSONLD(textContent(node))
+				}
+				return
+			case atom.Svg:
+				// SVG images have their own title elements.
+				return
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(doc)
+
+	meta := page.merge()
+	if len(page.canonical) > 0 {
+		meta.CanonicalURL = page.canonical
+	}
+	meta.Title = truncateString(collapseWhitespace(meta.Title), maxMetadataLength)
+	meta.Description = strings.TrimSpace(meta.Description)
+	meta.SiteName = truncateString(collapseWhitespace(meta.SiteName), maxMetadataLength)
+	meta.Author = truncateString(collapseWhitespace(meta.Author), maxMetadataLength)
+	meta.CanonicalURL = resolveURL(pageURL, meta.CanonicalURL)
+	meta.ImageURL = resolveURL(pageURL, meta.ImageURL)
+	meta.IconURL = resolveURL(pageURL, page.icon)
+	return meta
+}
+
+// hasToken checks if the given space-separated list contains the token, ignoring case.
+func hasToken(list, token string) bool {
+	for _, item := range strings.Fields(list) {
+		if strings.EqualFold(item, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveURL resolves the given reference against the base URL. Only http and https URLs are returned, and anything
+// else results in an empty string.
+func resolveURL(base, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if len(ref) == 0 {
+		return ""
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if baseURL, err := url.Parse(base); err == nil {
+		refURL = baseURL.ResolveReference(refURL)
+	}
+	if refURL.Scheme != "http" && refURL.Scheme != "https" {
+		return ""
+	}
+	return refURL.String()
+}
+
+// setIfEmpty sets the target to the value if the target is empty.
+func setIfEmpty(target *string, value string) {
+	if len(*target) == 0 {
+		*target = strings.TrimSpace(value)
+	}
+}
+
+func (page *pageMetadata) readMeta(node *html.Node) {
+	content := getAttr(node, "content")
+	if len(content) == 0 {
+		return
+	}
+	// OpenGraph uses the property attribute, but many sites use name for all meta tags.
+	name := strings.ToLower(strings.TrimSpace(getAttr(node, "property")))
+	if len(name) == 0 {
+		name = strings.ToLower(strings.TrimSpace(getAttr(node, "name")))
+	}
+	switch name {
+	case "og:title":
+		setIfEmpty(&page.openGraph.Title, content)
+	case "og:description":
+		setIfEmpty(&page.openGraph.Description, content)
+	case "og:image", "og:image:url", "og:image:secure_url":
+		setIfEmpty(&page.openGraph.ImageURL, content)
+	case "og:url":
+		setIfEmpty(&page.openGraph.CanonicalURL, content)
+	case "og:site_name":
+		setIfEmpty(&page.openGraph.SiteName, content)
+	case "article:author", "book:author":
+		// Facebook uses profile URLs as authors, which aren't useful as names.
+		if !strings.Contains(content, "://") {
+			setIfEmpty(&page.openGraph.Author, content)
+		}
+	case "article:published_time", "og:published_time":
+		if published, ok := parsePublishedTime(content); ok && page.openGraph.Published.IsZero() {
+			page.openGraph.Published = published
+		}
+	case "twitter:title":
+		setIfEmpty(&page.twitter.Title, content)
+	case "twitter:description":
+		setIfEmpty(&page.twitter.Description, content)
+	case "twitter:image", "twitter:image:src":
+		setIfEmpty(&page.twitter.ImageURL, content)
+	case "description":
+		setIfEmpty(&page.html.Description, content)
+	case "author", "dc.creator":
+		setIfEmpty(&page.html.Author, content)
+	case "application-name":
+		setIfEmpty(&page.html.SiteName, content)
+	case "date", "dc.date", "dc.date.issued", "pubdate":
+		if published, ok := parsePublishedTime(content); ok && page.html.Published.IsZero() {
+			page.html.Published = published
+		}
+	}
+}
+
+// readIcon reads a <link> element that may declare a favicon. The icon with the largest declared size is preferred, as
+// icons are scaled down to thumbnails. Apple touch icons are only used if there are no other icons, and SVG icons are
+// skipped, since they can't be made into thumbnails.
+func (page *pageMetadata) readIcon(node *html.Node) {
+	rel := getAttr(node, "rel")
+	href := strings.TrimSpace(getAttr(node, "href"))
+	if len(href) == 0 || strings.EqualFold(getAttr(node, "type"), "image/svg+xml") ||
+		strings.HasSuffix(strings.ToLower(href), ".svg") {
+		return
+	}
+	var size int
+	switch {
+	case hasToken(rel, "icon"):
+		// Icons without a declared size are usually the 16x16 or 32x32 favicon.ico.
+		size = 1
+		for _, declared := range strings.Fields(getAttr(node, "sizes")) {
+			var width, height int
+			if _, err := fmt.Sscanf(strings.ToLower(declared), "%dx%d", &width, &height); err == nil && width > size {
+				size = width
+			}
+		}
+	case hasToken(rel, "apple-touch-icon"), hasToken(rel, "apple-touch-icon-precomposed"):
+	default:
+		return
+	}
+	if len(page.icon) == 0 || size > page.iconSize {
+		page.icon = href
+		page.iconSize = size
+	}
+}
+
+// readJSONLD reads the metadata from the first object of a known type in a JSON-LD script. The script can contain a
+// single object, an array of objects or an object with a @graph array.
+func (page *pageMetadata) readJSONLD(data string) {
+	if len(page.jsonLD.Title) > 0 {
+		return
+	}
+	var parsed interface{}
+	if json.Unmarshal([]byte(data), &parsed) != nil {
+		return
+	}
+	obj := findJSONLDObject(parsed)
+	if obj == nil {
+		return
+	}
+	meta := &page.jsonLD
+	meta.Title = jsonLDString(obj["headline"])
+	setIfEmpty(&meta.Title, jsonLDString(obj["name"]))
+	meta.Description = jsonLDString(obj["description"])
+	meta.ImageURL = jsonLDString(obj["image"])
+	setIfEmpty(&meta.ImageURL, jsonLDString(obj["thumbnailUrl"]))
+	meta.CanonicalURL = jsonLDString(obj["url"])
+	meta.Author = jsonLDString(obj["author"])
+	if publisher, ok := obj["publisher"].(map[string]interface{}); ok {
+		meta.SiteName = jsonLDString(publisher["name"])
+	}
+	for _, key := range []string{"datePublished", "uploadDate", "dateCreated"} {
+		if published, ok := parsePublishedTime(jsonLDString(obj[key])); ok {
+			meta.Published = published
+			break
+		}
+	}
+}
+
+func findJSONLDObject(value interface{}) map[string]interface{} {
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, item := range typed {
+			if obj := findJSONLDObject(item); obj != nil {
+				return obj
+			}
+		}
+	case map[string]interface{}:
+		if isJSONLDType(typed["@type"]) {
+			return typed
+		}
+		if graph, ok := typed["@graph"]; ok {
+			return findJSONLDObject(graph)
+		}
+	}
+	return nil
+}
+
+func isJSONLDType(value interface{}) bool {
+	switch typed := value.(type) {
+	case string:
+		return jsonLDTypes[typed]
+	case []interface{}:
+		for _, item := range typed {
+			if isJSONLDType(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDString gets a string from a JSON-LD value. Objects like authors and images are converted using their name or
+// url, and the first item of arrays is used.
+func jsonLDString(value interface{}) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case []interface{}:
+		if len(typed) > 0 {
+			return jsonLDString(typed[0])
+		}
+	case map[string]interface{}:
+		if name := jsonLDString(typed["name"]); len(name) > 0 {
+			return name
+		}
+		return jsonLDString(typed["url"])
+	}
+	return ""
+}
+
+// merge combines the metadata from the different sources in order of preference.
+func (page *pageMetadata) merge() *Metadata {
+	meta := &Metadata{}
+	for _, source := range []*Metadata{&page.openGraph, &page.jsonLD, &page.twitter, &page.html} {
+		setIfEmpty(&meta.Title, source.Title)
+		setIfEmpty(&meta.Description, source.Description)
+		setIfEmpty(&meta.CanonicalURL, source.CanonicalURL)
+		setIfEmpty(&meta.ImageURL, source.ImageURL)
+		setIfEmpty(&meta.SiteName, source.SiteName)
+		setIfEmpty(&meta.Author, source.Author)
+		if meta.Published.IsZero() {
+			meta.Published = source.Published
+		}
+	}
+	return meta
+}
@@ -0,0 +1,212 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"rsc.io/pdf"
+)
+
+// maxPDFPages is the maximum number of pages whose text is extracted from a PDF file.
+const maxPDFPages = 500
+
+// maxPDFTextLength is the maximum number of bytes of text extracted from a PDF file.
+const maxPDFTextLength = 1024 * 1024
+
+// maxPDFTreeNodes and maxPDFTreeDepth limit how much of the page tree of a PDF file is walked. The tree is read from
+// the file, so it may be huge or even contain cycles.
+const maxPDFTreeNodes = 4 * maxPDFPages
+const maxPDFTreeDepth = 32
+
+// pdfExtractTimeout is how long extracting the text of a PDF file may take. The time is checked after each page, so
+// the text of the pages read until then is kept.
+const pdfExtractTimeout = 15 * time.Second
+
+// extractPDF reads the document info and the text of a PDF file. The PDF parser panics on some malformed files, so
+// panics are returned as errors.
+func extractPDF(body []byte) (meta *Metadata, article *Article, err error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			err = fmt.Errorf("malformed PDF: %v", panicErr)
+		}
+	}()
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := reader.Trailer().Key("Info")
+	meta = &Metadata{
+		Title:  collapseWhitespace(info.Key("Title").Text()),
+		Author: collapseWhitespace(info.Key("Author").Text()),
+	}
+	meta.Published, _ = parsePDFDate(info.Key("CreationDate").Text())
+
+	var pages []string
+	undecodable, textLength := 0, 0
+	deadline := time.Now().Add(pdfExtractTimeout)
+	for _, page := range pdfPages(reader.Trailer().Key("Root").Key("Pages")) {
+		text, ok := pdfPageText(page.Content().Text)
+		if !ok {
+			undecodable++
+		} else if len(text) > 0 {
+			pages = append(pages, text)
+			textLength += len(text)
+		}
+		if textLength >= maxPDFTextLength || time.Now().After(deadline) {
+			break
+		}
+	}
+	article = &Article{Text: truncateString(strings.Join(pages, "\n\n"), maxPDFTextLength)}
+	article.WordCount = len(strings.Fields(article.Text))
+	if len(meta.Title) == 0 && len(pages) > 0 {
+		// Use the first line of the document like with plain text files.
+		firstLine := pages[0]
+		if newline := strings.IndexByte(firstLine, '\n'); newline >= 0 {
+			firstLine = firstLine[:newline]
+		}
+		if len(firstLine) <= maxTitleLineLength {
+			meta.Title = firstLine
+		}
+	}
+	meta.Title = truncateString(meta.Title, maxMetadataLength)
+	meta.Author = truncateString(meta.Author, maxMetadataLength)
+	if undecodable > 0 && len(pages) == 0 {
+		return meta, article, fmt.Errorf("text of PDF uses an unsupported font encoding")
+	}
+	return meta, article, nil
+}
+
+// pdfPages gets at most maxPDFPages pages from the page tree of a PDF file in order. The page lookup of the parser
+// trusts the page counts in the file and doesn't detect cycles, so the tree is walked here with limits instead.
+func pdfPages(root pdf.Value) []pdf.Page {
+	var pages []pdf.Page
+	visited := 0
+	var walk func(node pdf.Value, depth int)
+	walk = func(node pdf.Value, depth int) {
+		visited++
+		switch node.Key("Type").Name() {
+		case "Pages":
+			kids := node.Key("Kids")
+			for index := 0; index < kids.Len(); index++ {
+				if visited >= maxPDFTreeNodes || len(pages) >= maxPDFPages || depth >= maxPDFTreeDepth {
+					return
+				}
+				walk(kids.Index(index), depth+1)
+			}
+		case "Page":
+			if pdfParentsEnd(node) {
+				pages = append(pages, pdf.Page{V: node})
+			}
+		}
+	}
+	walk(root, 0)
+	return pages
+}
+
+// pdfParentsEnd checks that the chain of parents of a page either ends or has the resources of the page within
+// maxPDFTreeDepth steps. The parser follows the chain without limits when it looks for the resources.
+func pdfParentsEnd(page pdf.Value) bool {
+	node := page
+	for depth := 0; depth <= maxPDFTreeDepth; depth++ {
+		if node.IsNull() || !node.Key("Resources").IsNull() {
+			return true
+		}
+		node = node.Key("Parent")
+	}
+	return false
+}
+
+// maxUndecodableRatio is the share of characters on a PDF page that may fail to decode before the text of the page is
+// considered garbage. Fonts without a Unicode mapping produce raw glyph IDs, which show up as control characters.
+const maxUndecodableRatio = 0.1
+
+// pdfPageText joins the positioned characters of a PDF page into lines. Characters are assumed to be in reading
+// order, which is the case for most PDFs that aren't scanned. If too many of the characters can't be decoded, false
+// is returned.
+func pdfPageText(chars []pdf.Text) (string, bool) {
+	var buf strings.Builder
+	var prev pdf.Text
+	undecodable := 0
+	for _, char := range chars {
+		if !isDecodedText(char.S) {
+			undecodable++
+			continue
+		}
+		if buf.Len() > 0 {
+			size := math.Max(prev.FontSize, 1)
+			if math.Abs(char.Y-prev.Y) > size/2 {
+				buf.WriteByte('\n')
+			} else if char.X-(prev.X+prev.W) > size*0.15 && !strings.HasSuffix(buf.String(), " ") {
+				buf.WriteByte(' ')
+			}
+		}
+		buf.WriteString(char.S)
+		prev = char
+	}
+	lines := strings.Split(buf.String(), "\n")
+	nonEmpty := lines[:0]
+	for _, line := range lines {
+		if line = collapseWhitespace(line); len(line) > 0 {
+			nonEmpty = append(nonEmpty, line)
+		}
+	}
+	if len(chars) > 0 && float64(undecodable)/float64(len(chars)) > maxUndecodableRatio {
+		return "", false
+	}
+	return strings.Join(nonEmpty, "\n"), true
+}
+
+// isDecodedText checks that the given text from a PDF is valid UTF-8 and contains no control characters.
+func isDecodedText(text string) bool {
+	for _, char := range text {
+		if char == utf8.RuneError || (unicode.IsControl(char) && !unicode.IsSpace(char)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePDFDate parses a PDF date string like D:20170102150405+02'00'. All parts after the year are optional.
+func parsePDFDate(value string) (time.Time, bool) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "D:")
+	digits := 0
+	for digits < len(value) && digits < 14 && value[digits] >= '0' && value[digits] <= '9' {
+		digits++
+	}
+	if digits < 4 || digits%2 != 0 {
+		return time.Time{}, false
+	}
+	// The missing parts default to the first month, day etc.
+	date := value[:digits] + "0101000000"[digits-4:]
+	zone := strings.Replace(value[digits:], "'", "", -1)
+	if len(zone) == 0 || zone == "Z" {
+		zone = "Z0000"
+	}
+	parsed, err := time.Parse("20060102150405Z0700", date+zone)
+	if err != nil {
+		parsed, err = time.Parse("20060102150405", date)
+	}
+	return parsed, err == nil
+}
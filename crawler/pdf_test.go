@@ -0,0 +1,150 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildPDF builds a PDF file with the given objects, numbered from 1. Object 1 must be the document catalog.
+func buildPDF(objects ...string) []byte {
+	var buf strings.Builder
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for index, object := range objects {
+		offsets[index] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", index+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(buf.String())
+}
+
+func pdfTextStream(text string) string {
+	content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+	return fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+}
+
+const pdfFont = "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+
+func TestExtractPDF(t *testing.T) {
+	body := buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		pdfFont,
+		pdfTextStream("First"),
+		pdfTextStream("Second"),
+	)
+	meta, article, err := extractPDF(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Text != "First\n\nSecond" {
+		t.Errorf("Text = %q, want both pages separated", article.Text)
+	}
+	if meta.Title != "First" {
+		t.Errorf("Title = %q, want first line", meta.Title)
+	}
+}
+
+func TestExtractPDFMaliciousPageTree(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []string
+		want    string
+	}{
+		{"huge page count", []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 2000000000 /Resources << /Font << /F1 4 0 R >> >> >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+			pdfFont,
+			pdfTextStream("Only"),
+		}, "Only"},
+		{"self-referencing pages", []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [2 0 R 3 0 R] /Count 2000000000 /Resources << /Font << /F1 4 0 R >> >> >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+			pdfFont,
+			pdfTextStream("Loop"),
+		}, ""},
+		{"parent cycle", []string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R 5 0 R] /Count 2 >>",
+			"<< /Type /Page /Parent 4 0 R /Contents 6 0 R >>",
+			"<< /Type /Pages /Parent 4 0 R >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 6 0 R /Resources << /Font << /F1 7 0 R >> >> >>",
+			pdfTextStream("Resources"),
+			pdfFont,
+		}, "Resources"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			done := make(chan struct{})
+			var article *Article
+			var err error
+			go func() {
+				_, article, err = extractPDF(buildPDF(test.objects...))
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("extractPDF didn't return")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(test.want) > 0 && article.Text != test.want {
+				t.Errorf("Text = %q, want %q", article.Text, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractPDFTextLimit(t *testing.T) {
+	line := strings.Repeat("word", 1000)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		pdfFont,
+		pdfTextStream(line),
+	}
+	var kids []string
+	for index := 0; index < maxPDFPages; index++ {
+		objects = append(objects, "<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>")
+		kids = append(kids, fmt.Sprintf("%d 0 R", len(objects)))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /Resources << /Font << /F1 3 0 R >> >> >>",
+		strings.Join(kids, " "), len(kids))
+
+	_, article, err := extractPDF(buildPDF(objects...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(article.Text) > maxPDFTextLength {
+		t.Errorf("Extracted %d bytes of text, want at most %d", len(article.Text), maxPDFTextLength)
+	}
+}
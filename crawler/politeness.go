@@ -0,0 +1,209 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostBusyError is returned by Fetch if the host of the page couldn't be requested within the maximum wait time,
+// either because of the per-host limits in the config or because the host asked to wait with a Retry-After header.
+type HostBusyError struct {
+	Host string
+	// RetryAt is the time when the host can be requested again.
+	RetryAt time.Time
+}
+
+func (err HostBusyError) Error() string {
+	return fmt.Sprintf("host %s is busy until %s", err.Host, err.RetryAt.Format(time.RFC3339))
+}
+
+const hostPollInterval = 100 * time.Millisecond
+
+// maxRetryAfter is the longest Retry-After that is honored. Longer waits are shortened to this.
+const maxRetryAfter = 24 * time.Hour
+
+// defaultRetryAfter is how long to leave a host alone after a 429 response without a Retry-After header.
+const defaultRetryAfter = time.Minute
+
+// maxCrawlDelay is the longest Crawl-delay from a robots.txt file that is honored.
+const maxCrawlDelay = time.Minute
+
+// pruneHostsAfter is the number of hosts the limiter can remember before it starts forgetting idle hosts.
+const pruneHostsAfter = 1024
+
+type hostState struct {
+	// active is the number of requests to the host that are currently running.
+	active int
+	// nextRequest is the earliest time when the next request can be started.
+	nextRequest time.Time
+	// blockedUntil is the time given by the host in a Retry-After header.
+	blockedUntil time.Time
+	// crawlDelay is the Crawl-delay from the robots.txt file of the host.
+	crawlDelay time.Duration
+}
+
+// hostLimiter limits the number of simultaneous requests to each host and the time between them.
+type hostLimiter struct {
+	lock        sync.Mutex
+	hosts       map[string]*hostState
+	concurrency int
+	delay       time.Duration
+	maxWait     time.Duration
+}
+
+func newHostLimiter(conf Config) *hostLimiter {
+	return &hostLimiter{
+		hosts:       make(map[string]*hostState),
+		concurrency: conf.HostConcurrency,
+		delay:       time.Duration(conf.HostDelay * float64(time.Second)),
+		maxWait:     time.Duration(conf.MaxHostWait) * time.Second,
+	}
+}
+
+// getState gets the state of the given host. The lock must be held when calling this.
+func (limiter *hostLimiter) getState(host string) *hostState {
+	state, ok := limiter.hosts[host]
+	if !ok {
+		if len(limiter.hosts) >= pruneHostsAfter {
+			limiter.prune()
+		}
+		state = &hostState{}
+		limiter.hosts[host] = state
+	}
+	return state
+}
+
+// prune forgets the hosts that have no running requests and no waits left. The lock must be held when calling this.
+func (limiter *hostLimiter) prune() {
+	now := time.Now()
+	for host, state := range limiter.hosts {
+		if state.active == 0 && state.nextRequest.Before(now) && state.blockedUntil.Before(now) {
+			delete(limiter.hosts, host)
+		}
+	}
+}
+
+// acquire waits until a request to the given host can be started. If that would take longer than the maximum wait
+// time, a HostBusyError is returned. Otherwise release must be called after the request is done.
+//
+// If delayed is false, the request is only limited by the number of simultaneous requests. It's used for the
+// resources of a page, which browsers also fetch all at once.
+func (limiter *hostLimiter) acquire(host string, delayed bool) error {
+	deadline := time.Now().Add(limiter.maxWait)
+	for {
+		limiter.lock.Lock()
+		state := limiter.getState(host)
+		now := time.Now()
+		readyAt := state.nextRequest
+		if !delayed {
+			readyAt = time.Time{}
+		}
+		if state.blockedUntil.After(readyAt) {
+			readyAt = state.blockedUntil
+		}
+		retryAt := readyAt
+		if !readyAt.After(now) {
+			if state.active < limiter.concurrency {
+				state.active++
+				if delayed {
+					delay := limiter.delay
+					if state.crawlDelay > delay {
+						delay = state.crawlDelay
+					}
+					state.nextRequest = now.Add(delay)
+				}
+				limiter.lock.Unlock()
+				return nil
+			}
+			// There's no way to know when the running requests finish, so just check again soon.
+			readyAt = now.Add(hostPollInterval)
+			retryAt = now.Add(limiter.maxWait)
+		}
+		limiter.lock.Unlock()
+		if readyAt.After(deadline) {
+			return HostBusyError{Host: host, RetryAt: retryAt}
+		}
+		time.Sleep(time.Until(readyAt))
+	}
+}
+
+// release marks a request to the given host as done.
+func (limiter *hostLimiter) release(host string) {
+	limiter.lock.Lock()
+	limiter.getState(host).active--
+	limiter.lock.Unlock()
+}
+
+// setCrawlDelay sets the minimum time between requests that the host asked for in its robots.txt file.
+func (limiter *hostLimiter) setCrawlDelay(host string, delay time.Duration) {
+	if delay > maxCrawlDelay {
+		delay = maxCrawlDelay
+	}
+	limiter.lock.Lock()
+	limiter.getState(host).crawlDelay = delay
+	limiter.lock.Unlock()
+}
+
+// backOff stops requests to the host after it responded with 429 Too Many Requests or 503 Service Unavailable. The
+// time to wait is read from the given Retry-After header. The wait time is returned.
+func (limiter *hostLimiter) backOff(host string, statusCode int, retryAfter string) time.Duration {
+	wait := parseRetryAfter(retryAfter, time.Now())
+	if wait == 0 && statusCode == http.StatusTooManyRequests {
+		wait = defaultRetryAfter
+	}
+	if wait == 0 {
+		return 0
+	}
+	until := time.Now().Add(wait)
+	limiter.lock.Lock()
+	state := limiter.getState(host)
+	if until.After(state.blockedUntil) {
+		state.blockedUntil = until
+	}
+	limiter.lock.Unlock()
+	return wait
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or a HTTP date. Zero
+// is returned if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+	var wait time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter
+		}
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+	}
+	if wait < 0 {
+		return 0
+	} else if wait > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return wait
+}
@@ -0,0 +1,59 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	date := func(offset time.Duration) string {
+		return now.Add(offset).Format(http.TimeFormat)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"empty", "", 0},
+		{"whitespace", "  ", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"padded seconds", " 30 ", 30 * time.Second},
+		{"zero seconds", "0", 0},
+		{"negative seconds", "-5", 0},
+		{"too many seconds", "1000000", maxRetryAfter},
+		{"overflowing seconds", "99999999999999999999", 0},
+		{"date", date(90 * time.Second), 90 * time.Second},
+		{"past date", date(-time.Hour), 0},
+		{"far date", date(48 * time.Hour), maxRetryAfter},
+		{"RFC 850 date", now.Add(time.Minute).Format(time.RFC850), time.Minute},
+		{"fraction", "1.5", 0},
+		{"invalid", "tomorrow", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseRetryAfter(test.value, now)
+			if got != test.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
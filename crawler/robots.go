@@ -0,0 +1,270 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RobotsDisallowedError is returned by Fetch if the robots.txt file of the website doesn't allow fetching the page.
+type RobotsDisallowedError struct {
+	URL string
+}
+
+func (err RobotsDisallowedError) Error() string {
+	return fmt.Sprintf("robots.txt disallows fetching %s", err.URL)
+}
+
+// maxRobotsSize is the maximum number of bytes read from a robots.txt file.
+const maxRobotsSize = 500 * 1024
+
+// robotsErrorCacheTime is how long a failure to fetch a robots.txt file is remembered.
+const robotsErrorCacheTime = 10 * time.Minute
+
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// robotsRules contains the rules of a robots.txt file that apply to the crawler.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// parseRobots parses the rules for the given user agent from a robots.txt file. The rules of the groups that name the
+// agent are used, or the rules of the * groups if no group names it.
+func parseRobots(body io.Reader, agent string) *robotsRules {
+	var specific, wildcard robotsRules
+	var matchesAgent, matchesWildcard bool
+	// A group that names the agent is used even if it has no rules, as that means everything is allowed for the agent.
+	foundAgent := false
+	// Consecutive user-agent lines form one group. A user-agent line after rules starts a new group.
+	inRules := false
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, '#'); comment >= 0 {
+			line = line[:comment]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		if key == "user-agent" {
+			if inRules {
+				matchesAgent, matchesWildcard = false, false
+				inRules = false
+			}
+			name := strings.ToLower(value)
+			if name == "*" {
+				matchesWildcard = true
+			} else if name == agent {
+				matchesAgent, foundAgent = true, true
+			}
+			continue
+		}
+
+		inRules = true
+		var target *robotsRules
+		if matchesAgent {
+			target = &specific
+		} else if matchesWildcard {
+			target = &wildcard
+		} else {
+			continue
+		}
+		switch key {
+		case "allow", "disallow":
+			// An empty disallow rule means that everything is allowed.
+			if len(value) > 0 {
+				target.rules = append(target.rules, robotsRule{pattern: value, allow: key == "allow"})
+			}
+		case "crawl-delay":
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				target.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	if foundAgent {
+		return &specific
+	}
+	return &wildcard
+}
+
+// allowed checks if the rules allow fetching the given path. The rule with the longest matching pattern is used,
+// and allow rules win ties.
+func (robots *robotsRules) allowed(path string) bool {
+	allow := true
+	longest := -1
+	for _, rule := range robots.rules {
+		if len(rule.pattern) < longest || !robotsPatternMatches(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || rule.allow {
+			allow = rule.allow
+		}
+		longest = len(rule.pattern)
+	}
+	return allow
+}
+
+// robotsPatternMatches checks if the path matches a robots.txt pattern, where * matches any characters and a $ at the
+// end means that the path must end there.
+func robotsPatternMatches(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for index, part := range parts[1:] {
+		if anchored && index == len(parts)-2 {
+			return strings.HasSuffix(path[pos:], part)
+		}
+		found := strings.Index(path[pos:], part)
+		if found < 0 {
+			return false
+		}
+		pos += found + len(part)
+	}
+	return !anchored || pos == len(path)
+}
+
+type robotsEntry struct {
+	rules   *robotsRules
+	err     error
+	expires time.Time
+}
+
+// robotsCache caches the parsed robots.txt files of websites.
+type robotsCache struct {
+	lock    sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+func (cache *robotsCache) get(site string) *robotsEntry {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry, ok := cache.entries[site]
+	if !ok || entry.expires.Before(time.Now()) {
+		return nil
+	}
+	return entry
+}
+
+func (cache *robotsCache) put(site string, entry *robotsEntry) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if len(cache.entries) >= pruneHostsAfter {
+		now := time.Now()
+		for key, oldEntry := range cache.entries {
+			if oldEntry.expires.Before(now) {
+				delete(cache.entries, key)
+			}
+		}
+	}
+	cache.entries[site] = entry
+}
+
+// checkRobots checks if the robots.txt file of the website allows fetching the given URL. The robots.txt file is
+// fetched if it's not cached. An error is returned if the URL is disallowed or the file couldn't be fetched.
+func (fetcher *Fetcher) checkRobots(pageURL *url.URL) error {
+	if fetcher.Config.IgnoreRobotsTxt || pageURL.EscapedPath() == "/robots.txt" {
+		return nil
+	}
+	site := pageURL.Scheme + "://" + strings.ToLower(pageURL.Host)
+	entry := fetcher.robots.get(site)
+	if entry == nil {
+		rules, err := fetcher.fetchRobots(site)
+		entry = &robotsEntry{rules: rules, err: err}
+		if err != nil {
+			entry.expires = time.Now().Add(robotsErrorCacheTime)
+		} else {
+			entry.expires = time.Now().Add(time.Duration(fetcher.Config.RobotsTxtCacheTime) * time.Second)
+		}
+		fetcher.robots.put(site, entry)
+	}
+	if entry.err != nil {
+		return entry.err
+	}
+	if entry.rules.crawlDelay > 0 {
+		fetcher.limiter.setCrawlDelay(strings.ToLower(pageURL.Host), entry.rules.crawlDelay)
+	}
+	path := pageURL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	if len(pageURL.RawQuery) > 0 {
+		path += "?" + pageURL.RawQuery
+	}
+	if !entry.rules.allowed(path) {
+		return RobotsDisallowedError{pageURL.String()}
+	}
+	return nil
+}
+
+// fetchRobots fetches and parses the robots.txt file of the given website. A missing file allows everything, but a
+// server error means that nothing can be fetched until the file is available.
+func (fetcher *Fetcher) fetchRobots(site string) (*robotsRules, error) {
+	req, err := http.NewRequest(http.MethodGet, site+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fetcher.Config.UserAgent)
+	req.Header.Set("Accept", "text/plain")
+	resp, err := fetcher.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch robots.txt: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, fmt.Errorf("failed to fetch robots.txt: %w", fetcher.httpError(resp))
+	case resp.StatusCode >= 400:
+		return &robotsRules{}, nil
+	}
+	return parseRobots(io.LimitReader(resp.Body, maxRobotsSize), fetcher.agentName), nil
+}
+
+// robotsAgentName gets the name used to find the rules for the crawler in robots.txt files, which is the first word
+// of the User-Agent header without the version.
+func robotsAgentName(userAgent string) string {
+	name := userAgent
+	if fields := strings.Fields(name); len(fields) > 0 {
+		name = fields[0]
+	}
+	if slash := strings.IndexByte(name, '/'); slash >= 0 {
+		name = name[:slash]
+	}
+	return strings.ToLower(name)
+}
@@ -0,0 +1,120 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/", true},
+		{"/", "/anything", true},
+		{"/private", "/private/page", true},
+		{"/private", "/privateer", true},
+		{"/private", "/public", false},
+		{"/private/", "/private", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/dir/index.php?page=2", true},
+		{"/*.php", "/index.html", false},
+		{"/*.php$", "/index.php", true},
+		{"/*.php$", "/index.php?page=2", false},
+		{"/index$", "/index", true},
+		{"/index$", "/index.html", false},
+		{"/a*b*c", "/a-b-c-d", true},
+		{"/a*b*c", "/a-c-b", false},
+		{"/a*ab$", "/ab", false},
+		{"/a*ab$", "/a-ab", true},
+		{"*", "/anything", true},
+		{"/*", "/", true},
+		{"/search?q=", "/search?q=go", true},
+	}
+	for _, test := range tests {
+		got := robotsPatternMatches(test.pattern, test.path)
+		if got != test.want {
+			t.Errorf("robotsPatternMatches(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		robots string
+		path   string
+		want   bool
+	}{
+		{"no rules", "", "/page", true},
+		{"disallow all", "User-agent: *\nDisallow: /", "/page", false},
+		{"empty disallow", "User-agent: *\nDisallow:", "/page", true},
+		{"longest match wins", "User-agent: *\nDisallow: /dir\nAllow: /dir/public", "/dir/public/page", true},
+		{"longest match wins disallow", "User-agent: *\nAllow: /dir\nDisallow: /dir/private", "/dir/private", false},
+		{"allow wins ties", "User-agent: *\nDisallow: /page\nAllow: /page", "/page", true},
+		{"wildcard pattern", "User-agent: *\nDisallow: /*.pdf$", "/files/doc.pdf", false},
+		{"anchored pattern", "User-agent: *\nDisallow: /*.pdf$", "/files/doc.pdf.html", true},
+		{"comments", "User-agent: * # everyone\nDisallow: /private # secret\n", "/private", false},
+		{"case-insensitive keys", "USER-AGENT: *\nDISALLOW: /private", "/private", false},
+		{"specific group wins", "User-agent: *\nDisallow: /\n\nUser-agent: lindeb\nDisallow: /private", "/page", true},
+		{"specific group rules", "User-agent: *\nDisallow: /\n\nUser-agent: lindeb\nDisallow: /private",
+			"/private", false},
+		{"empty specific group", "User-agent: *\nDisallow: /\n\nUser-agent: lindeb\nDisallow:", "/page", true},
+		{"specific group without rules", "User-agent: lindeb\n\nUser-agent: *\nDisallow: /", "/page", false},
+		{"agent case", "User-agent: *\nDisallow: /\n\nUser-agent: Lindeb\nAllow: /", "/page", true},
+		{"shared group", "User-agent: otherbot\nUser-agent: lindeb\nDisallow: /private", "/private", false},
+		{"other agent", "User-agent: otherbot\nDisallow: /", "/page", true},
+		{"group ends at next agent", "User-agent: lindeb\nDisallow: /a\nUser-agent: otherbot\nDisallow: /b",
+			"/b", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(test.robots), "lindeb")
+			got := rules.allowed(test.path)
+			if got != test.want {
+				t.Errorf("allowed(%q) = %v, want %v with rules %+v", test.path, got, test.want, rules.rules)
+			}
+		})
+	}
+}
+
+func TestParseRobotsCrawlDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		robots string
+		want   time.Duration
+	}{
+		{"none", "User-agent: *\nDisallow: /private", 0},
+		{"wildcard", "User-agent: *\nCrawl-delay: 2", 2 * time.Second},
+		{"fraction", "User-agent: *\nCrawl-delay: 0.5", 500 * time.Millisecond},
+		{"invalid", "User-agent: *\nCrawl-delay: soon", 0},
+		{"specific", "User-agent: *\nCrawl-delay: 10\n\nUser-agent: lindeb\nCrawl-delay: 1", time.Second},
+		{"specific without delay", "User-agent: *\nCrawl-delay: 10\n\nUser-agent: lindeb\nDisallow: /private", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseRobots(strings.NewReader(test.robots), "lindeb").crawlDelay
+			if got != test.want {
+				t.Errorf("crawlDelay = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// SnapshotLimits contains the limits of the resources that are included in page snapshots.
+type SnapshotLimits struct {
+	// MaxSize is the maximum size of a snapshot in bytes. Resources that don't fit are left out.
+	MaxSize int64
+	// MaxResourceSize is the maximum size of a single image, font or stylesheet in bytes.
+	MaxResourceSize int64
+	// MaxResources is the maximum number of images, fonts and stylesheets to fetch for a snapshot.
+	MaxResources int
+}
+
+// Snapshot is a self-contained copy of a page that can be viewed without the original website.
+type Snapshot struct {
+	ContentType string
+	Body        []byte
+}
+
+// ErrSnapshotTooLarge is returned by Snapshot if the page itself is larger than the maximum size of a snapshot.
+var ErrSnapshotTooLarge = errors.New("page is too large to archive")
+
+// Snapshot creates a self-contained copy of the given page. Scripts, frames and other active content are removed from
+// HTML pages, and their stylesheets, images and fonts are fetched and inlined as data URIs. Other pages are copied as
+// they are, except that text is converted into UTF-8.
+func (fetcher *Fetcher) Snapshot(page *Page, limits SnapshotLimits) (*Snapshot, error) {
+	if page.Truncated || int64(len(page.Body)) > limits.MaxSize {
+		return nil, ErrSnapshotTooLarge
+	}
+	switch {
+	case page.ContentType == "text/html" || page.ContentType == "application/xhtml+xml":
+		pageURL, err := url.Parse(page.URL)
+		if err != nil {
+			return nil, err
+		}
+		builder := &snapshotBuilder{
+			fetcher: fetcher,
+			limits:  limits,
+			base:    pageURL,
+			cache:   make(map[string]string),
+		}
+		body, err := builder.build(page.Text(), page.URL)
+		if err != nil {
+			return nil, err
+		}
+		return &Snapshot{ContentType: "text/html; charset=utf-8", Body: body}, nil
+	case strings.HasPrefix(page.ContentType, "text/"):
+		return &Snapshot{ContentType: "text/plain; charset=utf-8", Body: []byte(page.Text())}, nil
+	default:
+		return &Snapshot{ContentType: page.ContentType, Body: page.Body}, nil
+	}
+}
+
+// snapshotRemovedElements are the elements that are removed from snapshots, as they're either active content or
+// can't work without the original website.
+var snapshotRemovedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Iframe: true, atom.Frame: true, atom.Frameset: true, atom.Object: true,
+	atom.Embed: true, atom.Applet: true, atom.Template: true, atom.Base: true,
+}
+
+// snapshotURLAttributes are the attributes whose relative URLs are made absolute, so that links in snapshots still
+// point to the original website.
+var snapshotURLAttributes = map[string]bool{
+	"href": true, "src": true, "action": true, "formaction": true, "poster": true, "cite": true,
+}
+
+var (
+	cssImportPattern = regexp.MustCompile(`@import\s+(?:url\(\s*)?(?:"([^"]*)"|'([^']*)'|([^)'"\s;]+))\s*\)?([^;]*);`)
+	cssURLPattern    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+)
+
+// maxCSSImportDepth is how deep @import rules in stylesheets are followed.
+const maxCSSImportDepth = 2
+
+type snapshotBuilder struct {
+	fetcher *Fetcher
+	limits  SnapshotLimits
+	// base is the URL that relative URLs in the page are resolved against.
+	base *url.URL
+	// size is the size of the snapshot so far, including the inlined resources.
+	size int64
+	// resources is the number of resources fetched so far.
+	resources int
+	// cache contains the data URIs of resources that have already been fetched, so that resources used many times
+	// are only fetched and counted once. Resources that couldn't be fetched have an empty data URI.
+	cache map[string]string
+}
+
+func (builder *snapshotBuilder) build(body, pageURL string) ([]byte, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	builder.size = int64(len(body))
+	if base := findElement(doc, atom.Base); base != nil && len(getAttr(base, "href")) > 0 {
+		if baseURL, err := builder.base.Parse(getAttr(base, "href")); err == nil {
+			builder.base = baseURL
+		}
+	}
+	builder.clean(doc)
+
+	// The page is rendered as UTF-8, so any charset declarations are replaced.
+	if head := findElement(doc, atom.Head); head != nil {
+		head.InsertBefore(&html.Node{
+			Type:     html.ElementNode,
+			Data:     "meta",
+			DataAtom: atom.Meta,
+			Attr:     []html.Attribute{{Key: "charset", Val: "utf-8"}},
+		}, head.FirstChild)
+	}
+	comment := "Archived by lindeb from " + pageURL + " at " + time.Now().UTC().Format(time.RFC3339)
+	doc.InsertBefore(&html.Node{
+		Type: html.CommentNode,
+		Data: " " + strings.Replace(comment, "--", "%2D%2D", -1) + " ",
+	}, findElement(doc, atom.Html))
+
+	var buf bytes.Buffer
+	err = html.Render(&buf, doc)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// clean removes active content from the children of the given node and inlines the resources they use.
+func (builder *snapshotBuilder) clean(node *html.Node) {
+	for child := node.FirstChild; child != nil; {
+		// The child may be removed or replaced, so the next sibling is saved first.
+		next := child.NextSibling
+		if child.Type == html.ElementNode {
+			builder.cleanElement(child)
+		}
+		child = next
+	}
+}
+
+func (builder *snapshotBuilder) cleanElement(node *html.Node) {
+	parent := node.Parent
+	switch {
+	case snapshotRemovedElements[node.DataAtom]:
+		parent.RemoveChild(node)
+		return
+	case node.DataAtom == atom.Noscript:
+		// Scripts are removed, so the fallback content is shown instead. The parser doesn't parse the content of
+		// noscript elements, so it's parsed here.
+		builder.unwrapNoscript(node)
+		return
+	case node.DataAtom == atom.Meta:
+		if len(getAttr(node, "http-equiv")) > 0 || len(getAttr(node, "charset")) > 0 {
+			parent.RemoveChild(node)
+		}
+		return
+	case node.DataAtom == atom.Link:
+		builder.cleanLink(node)
+		return
+	case node.DataAtom == atom.Source && parent.DataAtom == atom.Picture:
+		// The responsive image sources would all have to be fetched, so only the fallback image is kept.
+		parent.RemoveChild(node)
+		return
+	case node.DataAtom == atom.Style:
+		if node.FirstChild != nil && node.FirstChild.Type == html.TextNode {
+			node.FirstChild.Data = builder.inlineCSS(node.FirstChild.Data, builder.base, 0)
+		}
+	case node.DataAtom == atom.Img:
+		builder.cleanImage(node)
+	}
+	builder.cleanAttributes(node)
+	builder.clean(node)
+}
+
+// cleanAttributes removes event handlers and javascript: URLs from the element, makes URLs absolute and inlines the
+// resources used in style attributes.
+func (builder *snapshotBuilder) cleanAttributes(node *html.Node) {
+	attrs := node.Attr[:0]
+	for _, attr := range node.Attr {
+		key := strings.ToLower(attr.Key)
+		value := strings.TrimSpace(attr.Val)
+		if strings.HasPrefix(key, "on") || strings.HasPrefix(strings.ToLower(value), "javascript:") {
+			continue
+		}
+		switch {
+		case key == "style":
+			attr.Val = builder.inlineCSS(attr.Val, builder.base, maxCSSImportDepth)
+		case snapshotURLAttributes[key] && !strings.HasPrefix(value, "data:") && !strings.HasPrefix(value, "#"):
+			if resolved, err := builder.base.Parse(value); err == nil {
+				attr.Val = resolved.String()
+			}
+		}
+		attrs = append(attrs, attr)
+	}
+	node.Attr = attrs
+}
+
+func (builder *snapshotBuilder) unwrapNoscript(node *html.Node) {
+	parent := node.Parent
+	if node.FirstChild != nil && node.FirstChild.Type == html.TextNode {
+		nodes, err := html.ParseFragment(strings.NewReader(node.FirstChild.Data), parent)
+		if err == nil {
+			for _, child := range nodes {
+				parent.InsertBefore(child, node)
+				if child.Type == html.ElementNode {
+					builder.cleanElement(child)
+				}
+			}
+		}
+	}
+	parent.RemoveChild(node)
+}
+
+// cleanLink replaces stylesheet links with style elements containing the stylesheet and inlines icons. Other links
+// are removed.
+func (builder *snapshotBuilder) cleanLink(node *html.Node) {
+	rel := getAttr(node, "rel")
+	href := getAttr(node, "href")
+	switch {
+	case hasToken(rel, "stylesheet") && !hasToken(rel, "alternate"):
+		css, cssURL, ok := builder.fetchStylesheet(builder.base, href)
+		if ok {
+			style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+			if media := getAttr(node, "media"); len(media) > 0 {
+				style.Attr = []html.Attribute{{Key: "media", Val: media}}
+			}
+			style.AppendChild(&html.Node{
+				Type: html.TextNode,
+				Data: builder.inlineCSS(css, cssURL, 0),
+			})
+			node.Parent.InsertBefore(style, node)
+		}
+	case hasToken(rel, "icon"):
+		if dataURI := builder.inlineResource(builder.base, href); len(dataURI) > 0 {
+			node.Attr = []html.Attribute{{Key: "rel", Val: "icon"}, {Key: "href", Val: dataURI}}
+			return
+		}
+	}
+	node.Parent.RemoveChild(node)
+}
+
+// cleanImage inlines the source of an image. Lazy-loaded images often have the real source in a data-src attribute
+// and a placeholder in src, so data-src is preferred.
+func (builder *snapshotBuilder) cleanImage(node *html.Node) {
+	src := getAttr(node, "data-src")
+	if len(src) == 0 {
+		src = getAttr(node, "src")
+	}
+	attrs := node.Attr[:0]
+	for _, attr := range node.Attr {
+		switch attr.Key {
+		case "src", "data-src", "srcset", "data-srcset", "sizes", "loading":
+		default:
+			attrs = append(attrs, attr)
+		}
+	}
+	node.Attr = attrs
+	if dataURI := builder.inlineResource(builder.base, src); len(dataURI) > 0 {
+		node.Attr = append(node.Attr, html.Attribute{Key: "src", Val: dataURI})
+	}
+}
+
+// inlineCSS inlines the imported stylesheets and the images and fonts used in the given CSS. Relative URLs are
+// resolved against the given base URL. Imports are only followed up to maxCSSImportDepth levels.
+func (builder *snapshotBuilder) inlineCSS(css string, base *url.URL, depth int) string {
+	css = cssImportPattern.ReplaceAllStringFunc(css, func(rule string) string {
+		if depth >= maxCSSImportDepth {
+			return ""
+		}
+		match := cssImportPattern.FindStringSubmatch(rule)
+		imported, importURL, ok := builder.fetchStylesheet(base, match[1]+match[2]+match[3])
+		if !ok {
+			return ""
+		}
+		imported = builder.inlineCSS(imported, importURL, depth+1)
+		if media := strings.TrimSpace(match[4]); len(media) > 0 {
+			return "@media " + media + " {\n" + imported + "\n}"
+		}
+		return imported
+	})
+	return cssURLPattern.ReplaceAllStringFunc(css, func(ref string) string {
+		match := cssURLPattern.FindStringSubmatch(ref)
+		dataURI := builder.inlineResource(base, match[1]+match[2]+match[3])
+		if len(dataURI) == 0 {
+			return ref
+		}
+		return `url("` + dataURI + `")`
+	})
+}
+
+// fetchStylesheet fetches the stylesheet at the given URL. The absolute URL of the stylesheet is returned for
+// resolving the URLs in it.
+func (builder *snapshotBuilder) fetchStylesheet(base *url.URL, ref string) (string, *url.URL, bool) {
+	cssURL, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || (cssURL.Scheme != "http" && cssURL.Scheme != "https") {
+		return "", nil, false
+	}
+	body, contentType, ok := builder.fetchResource(cssURL.String())
+	if !ok || contentType != "text/css" {
+		return "", nil, false
+	}
+	return strings.ToValidUTF8(string(body), "�"), cssURL, true
+}
+
+// inlineResource fetches the image or font at the given URL and returns it as a data URI. If the resource can't be
+// fetched or doesn't fit in the snapshot, an empty string is returned.
+func (builder *snapshotBuilder) inlineResource(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if len(ref) == 0 {
+		return ""
+	} else if strings.HasPrefix(ref, "data:") {
+		return ref
+	}
+	resourceURL, err := base.Parse(ref)
+	if err != nil || (resourceURL.Scheme != "http" && resourceURL.Scheme != "https") {
+		return ""
+	}
+	resourceURL.Fragment = ""
+	key := resourceURL.String()
+	if dataURI, ok := builder.cache[key]; ok {
+		return dataURI
+	}
+	builder.cache[key] = ""
+	body, contentType, ok := builder.fetchResource(key)
+	if !ok || !isInlinableType(contentType) {
+		return ""
+	}
+	dataURI := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(body)
+	if builder.size+int64(len(dataURI)) > builder.limits.MaxSize {
+		return ""
+	}
+	builder.size += int64(len(dataURI))
+	builder.cache[key] = dataURI
+	return dataURI
+}
+
+// isInlinableType checks if resources of the given media type can be inlined into snapshots.
+func isInlinableType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "font/") ||
+		strings.HasPrefix(mediaType, "application/font-") || strings.HasPrefix(mediaType, "application/x-font-") ||
+		mediaType == "application/vnd.ms-fontobject"
+}
+
+// fetchResource fetches a resource of a page within the limits of the snapshot. The media type of the resource is
+// returned along with the body.
+func (builder *snapshotBuilder) fetchResource(resourceURL string) (body []byte, mediaType string, ok bool) {
+	if builder.resources >= builder.limits.MaxResources {
+		return nil, "", false
+	}
+	builder.resources++
+	body, mediaType, err := builder.fetcher.fetchResource(resourceURL, builder.limits.MaxResourceSize)
+	return body, mediaType, err == nil
+}
+
+// errResourceTooLarge is returned by fetchResource if the resource is larger than the maximum size.
+var errResourceTooLarge = errors.New("resource is too large")
+
+// fetchResource fetches a resource of a page, such as an image or a stylesheet. Unlike Fetch, this doesn't wait for
+// the minimum time between requests to the host.
+func (fetcher *Fetcher) fetchResource(resourceURL string, maxSize int64) (body []byte, mediaType string, err error) {
+	req, err := http.NewRequest(http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	release, err := fetcher.startRequest(req, false)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release()
+	resp, err := fetcher.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, "", fetcher.httpError(resp)
+	}
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", err
+	} else if int64(len(body)) > maxSize {
+		return nil, "", errResourceTooLarge
+	}
+	mediaType, _, err = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return body, strings.ToLower(mediaType), nil
+}
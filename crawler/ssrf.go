@@ -0,0 +1,117 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// BlockedAddressError is returned by Fetch if the page or a redirect points to an address that can't be fetched.
+type BlockedAddressError struct {
+	IP net.IP
+}
+
+func (err BlockedAddressError) Error() string {
+	return fmt.Sprintf("address %s is blocked", err.IP)
+}
+
+// reservedNetworks contains the non-public ranges that the net.IP methods don't cover.
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "This" network
+	"100.64.0.0/10", // Carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // Benchmarking
+	"240.0.0.0/4",   // Reserved, including broadcast
+	"64:ff9b::/96",  // NAT64, which can map to any IPv4 address
+	"2001:db8::/32", // Documentation
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, len(cidrs))
+	for index, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", cidr, err)
+		}
+		networks[index] = network
+	}
+	return networks, nil
+}
+
+// addressFilter decides which IP addresses the fetcher can connect to.
+type addressFilter struct {
+	blocked []*net.IPNet
+	allowed []*net.IPNet
+}
+
+func newAddressFilter(blocked, allowed []string) (filter *addressFilter, err error) {
+	filter = &addressFilter{}
+	if filter.blocked, err = parseCIDRs(blocked); err != nil {
+		return nil, err
+	} else if filter.allowed, err = parseCIDRs(allowed); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed checks if the given address can be connected to.
+func (filter *addressFilter) isAllowed(ip net.IP) bool {
+	if containsIP(filter.allowed, ip) {
+		return true
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() &&
+		!containsIP(reservedNetworks, ip) && !containsIP(filter.blocked, ip)
+}
+
+// control is used as the Control function of the dialer. It's called with the resolved address right before
+// connecting.
+func (filter *addressFilter) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", host)
+	} else if !filter.isAllowed(ip) {
+		return BlockedAddressError{ip}
+	}
+	return nil
+}
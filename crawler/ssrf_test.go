@@ -0,0 +1,143 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package crawler
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddressFilter(t *testing.T) {
+	filter, err := newAddressFilter([]string{"203.0.113.0/24", "2001:db9::/32"}, []string{"10.1.0.0/16", "127.0.0.2/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		ip      string
+		allowed bool
+	}{
+		{"public IPv4", "93.184.216.34", true},
+		{"public IPv6", "2606:2800:220:1::1", true},
+		{"loopback", "127.0.0.1", false},
+		{"loopback range", "127.8.8.8", false},
+		{"IPv6 loopback", "::1", false},
+		{"unspecified", "0.0.0.0", false},
+		{"IPv6 unspecified", "::", false},
+		{"RFC 1918 10/8", "10.0.0.1", false},
+		{"RFC 1918 172.16/12", "172.16.5.4", false},
+		{"RFC 1918 192.168/16", "192.168.1.1", false},
+		{"outside 172.16/12", "172.32.0.1", true},
+		{"unique local", "fd00::1", false},
+		{"link-local", "169.254.1.1", false},
+		{"metadata", "169.254.169.254", false},
+		{"IPv6 link-local", "fe80::1", false},
+		{"multicast", "224.0.0.1", false},
+		{"carrier-grade NAT", "100.64.0.1", false},
+		{"broadcast", "255.255.255.255", false},
+		{"IPv4-mapped loopback", "::ffff:127.0.0.1", false},
+		{"IPv4-mapped private", "::ffff:10.0.0.1", false},
+		{"IPv4-mapped metadata", "::ffff:169.254.169.254", false},
+		{"IPv4-mapped public", "::ffff:93.184.216.34", true},
+		{"NAT64", "64:ff9b::7f00:1", false},
+		{"configured network", "203.0.113.7", false},
+		{"IPv4-mapped configured network", "::ffff:203.0.113.7", false},
+		{"configured IPv6 network", "2001:db9::1", false},
+		{"allowed private network", "10.1.2.3", true},
+		{"IPv4-mapped allowed network", "::ffff:10.1.2.3", true},
+		{"outside allowed network", "10.2.0.1", false},
+		{"allowed loopback address", "127.0.0.2", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := net.ParseIP(test.ip)
+			if ip == nil {
+				t.Fatalf("Invalid test address %q", test.ip)
+			}
+			if got := filter.isAllowed(ip); got != test.allowed {
+				t.Errorf("isAllowed(%s) = %t, want %t", test.ip, got, test.allowed)
+			}
+		})
+	}
+}
+
+func TestNewAddressFilterInvalidNetwork(t *testing.T) {
+	if _, err := newAddressFilter([]string{"10.0.0.0"}, nil); err == nil {
+		t.Error("Blocked network without prefix length was accepted")
+	}
+	if _, err := newAddressFilter(nil, []string{"not a network"}); err == nil {
+		t.Error("Invalid allowed network was accepted")
+	}
+}
+
+func TestFetchBlockedAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loopback":
+			http.Redirect(w, r, "http://127.0.0.3/", http.StatusFound)
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	blocked, err := NewFetcher(Config{IgnoreRobotsTxt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := NewFetcher(Config{IgnoreRobotsTxt: true, AllowedNetworks: []string{"127.0.0.1/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		fetcher *Fetcher
+		path    string
+		blocked string
+	}{
+		{"loopback", blocked, "/", "127.0.0.1"},
+		{"allowed address", allowed, "/", ""},
+		{"redirect to loopback", allowed, "/loopback", "127.0.0.3"},
+		{"redirect to metadata", allowed, "/metadata", "169.254.169.254"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := test.fetcher.Fetch(server.URL + test.path)
+			if len(test.blocked) == 0 {
+				if err != nil {
+					t.Fatalf("Fetch returned error: %v", err)
+				} else if string(page.Body) != "ok" {
+					t.Errorf("Fetch returned body %q, want %q", page.Body, "ok")
+				}
+				return
+			}
+			var blockedErr BlockedAddressError
+			if !errors.As(err, &blockedErr) {
+				t.Fatalf("Fetch returned %v, want BlockedAddressError", err)
+			} else if blockedErr.IP.String() != test.blocked {
+				t.Errorf("Fetch blocked %s, want %s", blockedErr.IP, test.blocked)
+			}
+		})
+	}
+}
@@ -40,21 +40,14 @@ func (user *User) GenerateAuthToken() *AuthToken {
 }
 
 // DeleteAllTokens deletes all auth tokens associated with this user.
-func (user *User) DeleteAllTokens() (err error) {
-	_, err = user.DB.Exec("DELETE FROM AuthToken WHERE user=?", user.ID)
-	return
+func (user *User) DeleteAllTokens() error {
+	return user.DB.Storage.DeleteAuthTokens(user)
 }
 
 // GetToken gets the metadata associated with the given auth token.
 func (user *User) GetToken(token string) *AuthToken {
-	row := user.DB.QueryRow("SELECT token FROM AuthToken WHERE token=SHA2(?, 256) AND user=?", token, user.ID)
-	if row == nil {
-		return nil
-	}
-
-	var hashedToken string
-	row.Scan(&hashedToken)
-	if len(hashedToken) == 0 {
+	found, _ := user.DB.Storage.HasAuthToken(user, token)
+	if !found {
 		return nil
 	}
 
@@ -76,13 +69,11 @@ func (user *User) CheckToken(token string) bool {
 }
 
 // Delete invalidates this auth token.
-func (at *AuthToken) Delete() (err error) {
-	_, err = at.DB.Exec("DELETE FROM AuthToken WHERE token=SHA2(?, 256) AND user=?", at.Token, at.User.ID)
-	return
+func (at *AuthToken) Delete() error {
+	return at.DB.Storage.DeleteAuthToken(at)
 }
 
 // Insert inserts this auth token into the database.
-func (at *AuthToken) Insert() (err error) {
-	_, err = at.DB.Exec("INSERT INTO AuthToken (user, token) VALUES (?, SHA2(?, 256))", at.User.ID, at.Token)
-	return
+func (at *AuthToken) Insert() error {
+	return at.DB.Storage.InsertAuthToken(at)
 }
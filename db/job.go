@@ -0,0 +1,166 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"time"
+)
+
+// JobType is the type of a background job.
+type JobType string
+
+const (
+	// JobCrawl fetches the page of a link, stores the title, description and crawl status of the link and indexes it.
+	JobCrawl JobType = "crawl"
+	// JobIndex indexes a link. The payload is the readable text of the page, or empty to keep the content already in
+	// the index.
+	JobIndex JobType = "index"
+	// JobDelete removes a link from the search index.
+	JobDelete JobType = "delete"
+)
+
+// JobStatus is the state of a background job.
+type JobStatus string
+
+const (
+	// JobPending means that the job is waiting to be run or retried.
+	JobPending JobStatus = "pending"
+	// JobRunning means that a worker is currently running the job.
+	JobRunning JobStatus = "running"
+	// JobDone means that the job completed successfully.
+	JobDone JobStatus = "done"
+	// JobDead means that the job failed too many times and will not be retried automatically.
+	JobDead JobStatus = "dead"
+)
+
+// Job represents a single background job stored in the database.
+type Job struct {
+	DB *DB
+
+	ID        int
+	Type      JobType
+	Owner     int
+	Link      int
+	Payload   string
+	Status    JobStatus
+	Attempts  int
+	LastError string
+	RunAt     int64
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+// NewJob creates a new pending job and inserts it into the database.
+func (db *DB) NewJob(jobType JobType, owner, link int, payload string) (*Job, error) {
+	now := time.Now().Unix()
+	job := &Job{
+		DB:        db,
+		Type:      jobType,
+		Owner:     owner,
+		Link:      link,
+		Payload:   payload,
+		Status:    JobPending,
+		RunAt:     now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return job, db.Storage.InsertJob(job)
+}
+
+// GetJob gets the job with the given ID. If the job is not found, nil is returned.
+func (db *DB) GetJob(id int) (job *Job) {
+	job, _ = db.Storage.GetJob(id)
+	return
+}
+
+// GetJobs gets at most limit jobs with the given status, newest first.
+func (db *DB) GetJobs(status JobStatus, limit int) ([]*Job, error) {
+	return db.Storage.GetJobs(status, limit)
+}
+
+// GetJobsRunSince gets the jobs that are running or have been completed since the given time, oldest first. If owner
+// is not zero, only the jobs of that owner are returned.
+func (db *DB) GetJobsRunSince(owner int, since time.Time) ([]*Job, error) {
+	return db.Storage.GetJobsRunSince(owner, since.Unix())
+}
+
+// ClaimJob marks the oldest job that is due as running and returns it. If there are no jobs to run, nil is returned.
+func (db *DB) ClaimJob() (*Job, error) {
+	return db.Storage.ClaimJob(time.Now().Unix())
+}
+
+// ResetRunningJobs marks all running jobs as pending. This should be called on startup, as jobs that are still
+// running at that point were interrupted by a shutdown.
+func (db *DB) ResetRunningJobs() error {
+	return db.Storage.ResetRunningJobs()
+}
+
+// DeleteDoneJobs deletes successfully completed jobs that were last updated before the given time.
+func (db *DB) DeleteDoneJobs(before time.Time) error {
+	return db.Storage.DeleteJobs(JobDone, before.Unix())
+}
+
+// GetLinkJob gets the latest job of the given link. If the link has no jobs, nil is returned.
+func (link *Link) GetLinkJob() (job *Job) {
+	job, _ = link.DB.Storage.GetLinkJob(link.Owner.ID, link.ID)
+	return
+}
+
+// Complete marks the job as successfully completed.
+func (job *Job) Complete() error {
+	job.Status = JobDone
+	job.LastError = ""
+	return job.Update()
+}
+
+// Fail stores the error of a failed attempt and schedules a retry after the given delay. If the job has already been
+// attempted maxAttempts times, it is marked dead instead.
+func (job *Job) Fail(err error, maxAttempts int, retryDelay time.Duration) error {
+	job.LastError = err.Error()
+	if job.Attempts >= maxAttempts {
+		job.Status = JobDead
+	} else {
+		job.Status = JobPending
+		job.RunAt = time.Now().Add(retryDelay).Unix()
+	}
+	return job.Update()
+}
+
+// Postpone schedules the job to be run again at the given time without counting the current attempt. It's used when
+// the job couldn't be started yet rather than failing.
+func (job *Job) Postpone(runAt time.Time) error {
+	job.Status = JobPending
+	if job.Attempts > 0 {
+		job.Attempts--
+	}
+	job.RunAt = runAt.Unix()
+	return job.Update()
+}
+
+// Retry resets the attempt counter of the job and schedules it to be run immediately.
+func (job *Job) Retry() error {
+	job.Status = JobPending
+	job.Attempts = 0
+	job.RunAt = time.Now().Unix()
+	return job.Update()
+}
+
+// Update updates the status of this job in the database.
+func (job *Job) Update() error {
+	job.UpdatedAt = time.Now().Unix()
+	return job.DB.Storage.UpdateJob(job)
+}
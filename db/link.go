@@ -17,11 +17,27 @@
 package db
 
 import (
-	"database/sql"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
+
+	"maunium.net/go/lindeb/util"
+)
+
+// CrawlStatus is the state of fetching the page of a link.
+type CrawlStatus string
+
+const (
+	// CrawlPending means that the page hasn't been fetched yet.
+	CrawlPending CrawlStatus = "pending"
+	// CrawlDone means that the page was fetched successfully.
+	CrawlDone CrawlStatus = "done"
+	// CrawlFailed means that the page couldn't be fetched.
+	CrawlFailed CrawlStatus = "failed"
+	// CrawlBlocked means that the crawler isn't allowed to fetch the address of the page.
+	CrawlBlocked CrawlStatus = "blocked"
+	// CrawlDisallowed means that the robots.txt file of the website doesn't allow fetching the page.
+	CrawlDisallowed CrawlStatus = "disallowed"
 )
 
 // Link represents a single link saved by a specific user.
@@ -35,6 +51,59 @@ type Link struct {
 	Timestamp   int64
 	URL         *url.URL
 	Tags        []string
+	Read        bool
+
+	// CustomTitle and CustomDescription are true if the title or description was given by the user, in which case
+	// the crawler must not replace them with the ones found on the page.
+	CustomTitle       bool
+	CustomDescription bool
+
+	CrawlStatus CrawlStatus
+	// CrawledAt is the time when the page was last fetched, or zero if it hasn't been fetched.
+	CrawledAt int64
+	// HTTPStatus is the status code the page was last fetched with, or zero if no response was received.
+	HTTPStatus int
+
+	// The article data and metadata found on the page by the crawler.
+	WordCount int
+	// Language is the ISO 639 code of the language of the page, or empty if it's not known.
+	Language string
+	Author   string
+	// PublishedAt is the unix timestamp of when the page was published, or zero if it's not known.
+	PublishedAt int64
+	// CanonicalURL is the preferred URL of the page according to the page itself.
+	CanonicalURL string
+	// ImageURL is the preview image of the page.
+	ImageURL string
+	SiteName string
+
+	// The result of the latest health check, which is done when the page is crawled and periodically after that.
+	// HealthStatus is the HTTP status code of the check, or zero if no response was received.
+	HealthStatus int
+	// FinalURL is the URL the link redirected to in the check, or empty if it didn't redirect.
+	FinalURL string
+	// HealthCheckedAt is the time of the check, or zero if the link hasn't been checked.
+	HealthCheckedAt int64
+	// HealthFailures is the number of consecutive checks that have failed.
+	HealthFailures int
+
+	// The latest snapshot of the page in the archive. The snapshot is kept if later crawls fail.
+	// ArchiveHash is the hash of the snapshot in the archive store, or empty if the page hasn't been archived.
+	ArchiveHash string
+	// ArchiveType is the content type of the snapshot.
+	ArchiveType string
+	// ArchivedAt is the time when the snapshot was taken.
+	ArchivedAt int64
+
+	// The hashes of the favicon of the website and the preview image of the page in the thumbnail cache, or empty if
+	// they haven't been cached.
+	FaviconHash   string
+	ThumbnailHash string
+
+	// NormalizedURL is the URL in a form where different ways of writing the same address are equal, or the
+	// normalized canonical URL of the page once it has been crawled. Links with the same normalized URL are
+	// duplicates.
+	NormalizedURL string
 }
 
 // BlankLink creates a blank link.
@@ -45,73 +114,40 @@ func (user *User) BlankLink() *Link {
 	}
 }
 
-// scanLink scans a database row into a Link object.
-func (user *User) scanLink(row Scannable) (*Link, error) {
-	var id, ownerID int
-	var timestamp int64
-	var urlString, domain, title, description, tagsString string
-	err := row.Scan(&id, &urlString, &domain, &title, &description, &timestamp, &ownerID, &tagsString)
-	if err != nil {
-		return nil, err
-	}
-	parsedURL, err := url.Parse(urlString)
-	if err != nil {
-		return nil, err
-	}
-	var tags []string
-	if len(tagsString) > 0 {
-		tags = strings.Split(tagsString, ",")
-	}
-	return &Link{
-		DB:    user.DB,
-		Owner: user,
-
-		ID:          id,
-		Title:       title,
-		Description: description,
-		Timestamp:   timestamp,
-		URL:         parsedURL,
-		Tags:        tags,
-	}, nil
-}
-
-// scanLinks scans multiple database rows into an array of Links.
-func (user *User) scanLinks(rows *sql.Rows) ([]*Link, error) {
-	var links []*Link
-	for rows.Next() {
-		link, err := user.scanLink(rows)
-		if err != nil {
-			return links, err
-		}
-		links = append(links, link)
-	}
-	return links, nil
-}
-
 // GetLink tries to find a link from the database, and returns nil if something goes wrong.
 func (user *User) GetLink(id int) (link *Link) {
-	linkRow := user.DB.QueryRow(`SELECT Link.*, IFNULL(GROUP_CONCAT(Tag.name), "") AS tags FROM Link
-		LEFT JOIN LinkTag ON LinkTag.link = Link.id
-		LEFT JOIN Tag ON LinkTag.tag = Tag.id
-		WHERE Link.id=? AND Link.owner=?
-		GROUP BY Link.id`, id, user.ID)
-	if linkRow != nil {
-		link, _ = user.scanLink(linkRow)
-	}
+	link, _ = user.DB.Storage.GetLink(user, id)
 	return
 }
 
 // GetLinks gets all the links owned by this user.
 func (user *User) GetLinks() ([]*Link, error) {
-	results, err := user.DB.Query(`SELECT Link.*, IFNULL(GROUP_CONCAT(Tag.name), "") AS tags FROM Link
-		LEFT JOIN LinkTag ON LinkTag.link = Link.id
-		LEFT JOIN Tag ON LinkTag.tag = Tag.id
-		WHERE Link.owner = ?
-		GROUP BY Link.id ORDER BY Link.ID DESC`, user.ID)
-	if err != nil {
-		return nil, err
-	}
-	return user.scanLinks(results)
+	return user.DB.Storage.GetLinks(user)
+}
+
+// SuggestLinkTitles gets at most limit links of this user that have a word in the title starting with the given
+// prefix, newest first.
+func (user *User) SuggestLinkTitles(prefix string, limit int) ([]*Link, error) {
+	return user.DB.Storage.SuggestLinkTitles(user, prefix, limit)
+}
+
+// SuggestDomains gets at most limit domains of this user's links that start with the given prefix, ignoring a www.
+// prefix in the domain. The domains with the most links come first.
+func (user *User) SuggestDomains(prefix string, limit int) ([]string, error) {
+	return user.DB.Storage.SuggestDomains(user, prefix, limit)
+}
+
+// RelatedLink is a link that is similar to another link.
+type RelatedLink struct {
+	*Link
+	// Score is the number of tags shared with the other link, plus 0.5 if the links are in the same domain.
+	Score float64
+}
+
+// GetRelated gets at most limit links of the same user that share tags or the domain with this link, most similar
+// first.
+func (link *Link) GetRelated(limit int) ([]*RelatedLink, error) {
+	return link.DB.Storage.GetRelatedLinks(link, limit)
 }
 
 // UpdateTags updates the tags of this link both in the database and in memory.
@@ -138,23 +174,10 @@ func (link *Link) UpdateTags(tags []string) error {
 		}
 	}
 
-	// To make sure that there are no old tags, we just delete everything and re-add the requested tags.
-	_, err = link.DB.Exec("DELETE FROM LinkTag WHERE link=?", link.ID)
+	err = link.DB.Storage.SetLinkTags(link, tagObjs)
 	if err != nil {
 		return err
 	}
-
-	if len(tagObjs) > 0 {
-		stmt, _ := link.DB.Prepare("INSERT INTO LinkTag (link, tag) VALUES (?, ?)")
-		for _, tag := range tagObjs {
-			stmt.Exec(link.ID, tag.ID)
-		}
-
-		err = stmt.Close()
-		if err != nil {
-			return err
-		}
-	}
 	link.Tags = tags
 	return nil
 }
@@ -217,35 +240,86 @@ Outer:
 	return len(tagsToCheck) == 0
 }
 
-// Update touches the timestamp of this link and updates the data of this link in the database.
-func (link *Link) Update() (err error) {
+// UpdateFields touches the timestamp of this link and stores the URL, title, description and read status of this link
+// in the database. The crawl status is only stored if resetCrawl is true. Crawl results, health, snapshots and
+// thumbnails are never stored, as they may be updated by a crawl or health check at the same time.
+func (link *Link) UpdateFields(resetCrawl bool) error {
 	link.Timestamp = time.Now().Unix()
-	_, err = link.DB.Exec(
-		"UPDATE Link SET url=?,domain=?,title=?,description=?,timestamp=? WHERE id=? AND owner=?",
-		link.URL.String(), link.URL.Hostname(), link.Title, link.Description, link.Timestamp, link.ID, link.Owner.ID)
-	return
+	return link.DB.Storage.UpdateLinkFields(link, resetCrawl)
 }
 
-// Insert touches the timestamp of this link,
-// stores the data of this link into the database
-// and fills in the ID field of the struct with the ID of the inserted row.
-func (link *Link) Insert() error {
-	result, err := link.DB.Exec(
-		"INSERT INTO Link (url, domain, title, description, timestamp, owner) VALUES (?, ?, ?, ?, ?, ?)",
-		link.URL.String(), link.URL.Hostname(), link.Title, link.Description, link.Timestamp, link.Owner.ID)
-	if err != nil {
-		return err
+// UpdateCrawlResult stores the crawl status, article data, metadata, snapshot and thumbnails of this link in the
+// database. The title and description are only stored if they haven't been set by the user. Unlike UpdateFields, this
+// doesn't touch the timestamp.
+//
+// If the URL of the link has been changed in the database since the link was loaded, the result is for the old URL
+// and nothing is stored. In that case, or if the link has been deleted, stored is false.
+func (link *Link) UpdateCrawlResult() (stored bool, err error) {
+	return link.DB.Storage.UpdateLinkCrawlResult(link)
+}
+
+// SetHealth records the result of checking whether the link still works. The final URL is only stored if it's
+// different from the URL of the link. Failed checks increment the number of consecutive failures and successful ones
+// reset it.
+func (link *Link) SetHealth(statusCode int, finalURL string, ok bool) {
+	link.HealthStatus = statusCode
+	link.FinalURL = ""
+	if len(finalURL) > 0 && finalURL != link.URL.String() {
+		link.FinalURL = finalURL
 	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
+	link.HealthCheckedAt = time.Now().Unix()
+	if ok {
+		link.HealthFailures = 0
+	} else {
+		link.HealthFailures++
 	}
-	link.ID = int(id)
-	return nil
+}
+
+// ResetHealth clears the health check result of this link. It's used when the URL of the link changes.
+func (link *Link) ResetHealth() {
+	link.HealthStatus, link.FinalURL, link.HealthCheckedAt, link.HealthFailures = 0, "", 0, 0
+}
+
+// IsBroken checks if the latest health check of this link failed.
+func (link *Link) IsBroken() bool {
+	return link.HealthFailures > 0
+}
+
+// UpdateHealth stores the health check result of this link in the database. Unlike UpdateFields, this doesn't touch
+// the timestamp. The result isn't stored if the URL of the link has been changed since the link was loaded.
+func (link *Link) UpdateHealth() error {
+	return link.DB.Storage.UpdateLinkHealth(link)
+}
+
+// GetLinksToCheck gets at most limit links of this user that haven't been checked since the given time, least
+// recently checked first. Links that haven't been crawled yet are not included.
+func (user *User) GetLinksToCheck(checkedBefore int64, limit int) ([]*Link, error) {
+	return user.DB.Storage.GetLinksToCheck(user, checkedBefore, limit)
+}
+
+// NormalizeURL sets the normalized URL of this link from its URL.
+func (link *Link) NormalizeURL() {
+	link.NormalizedURL = util.NormalizeURL(link.URL)
+}
+
+// GetLinksByNormalizedURL gets the links of this user that have the given normalized URL, oldest first.
+func (user *User) GetLinksByNormalizedURL(normalizedURL string) ([]*Link, error) {
+	return user.DB.Storage.GetLinksByNormalizedURL(user, normalizedURL)
+}
+
+// GetDuplicateLinks gets the links of this user that have the same normalized URL as another link of the user. The
+// links are ordered by normalized URL, and the links with the same normalized URL are ordered from oldest to newest.
+func (user *User) GetDuplicateLinks() ([]*Link, error) {
+	return user.DB.Storage.GetDuplicateLinks(user)
+}
+
+// Insert stores the data of this link into the database
+// and fills in the ID field of the struct with the ID of the inserted row.
+func (link *Link) Insert() error {
+	return link.DB.Storage.InsertLink(link)
 }
 
 // Delete deletes this Link from the database.
-func (link *Link) Delete() (err error) {
-	_, err = link.DB.Exec("DELETE FROM Link WHERE owner=? AND id=?", link.Owner.ID, link.ID)
-	return
+func (link *Link) Delete() error {
+	return link.DB.Storage.DeleteLink(link)
 }
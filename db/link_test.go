@@ -0,0 +1,198 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"net/url"
+	"os"
+	"testing"
+)
+
+// openTestDB creates an empty in-memory SQLite database with the latest schema. If the LINDEB_TEST_MYSQL environment
+// variable contains a DSN, the tests are run against that MySQL database instead. It has to be empty, as the tests
+// don't clean up after themselves.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	conf := Config("sqlite://:memory:")
+	mysqlDSN := os.Getenv("LINDEB_TEST_MYSQL")
+	if len(mysqlDSN) > 0 {
+		conf = Config("mysql://" + mysqlDSN)
+	}
+	db, err := conf.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	err = db.Upgrade()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func insertTestLink(t *testing.T, user *User, rawURL string) *Link {
+	t.Helper()
+	link := user.BlankLink()
+	var err error
+	link.URL, err = url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link.Title = rawURL
+	link.CrawlStatus = CrawlPending
+	link.NormalizeURL()
+	err = link.Insert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+func TestNormalizedURLIsCaseSensitive(t *testing.T) {
+	db := openTestDB(t)
+	user := db.NewUser(t.Name(), "password")
+	lower := insertTestLink(t, user, "https://www.youtube.com/watch?v=abcdef")
+	upper := insertTestLink(t, user, "https://www.youtube.com/watch?v=ABCDEF")
+
+	for _, link := range []*Link{lower, upper} {
+		links, err := user.GetLinksByNormalizedURL(link.NormalizedURL)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(links) != 1 || links[0].ID != link.ID {
+			t.Errorf("GetLinksByNormalizedURL(%q) returned %d links, want only #%d",
+				link.NormalizedURL, len(links), link.ID)
+		}
+	}
+
+	duplicates, err := user.GetDuplicateLinks()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(duplicates) != 0 {
+		t.Errorf("GetDuplicateLinks returned %d links, want none", len(duplicates))
+	}
+
+	insertTestLink(t, user, "http://www.youtube.com/watch?v=abcdef&utm_source=feed")
+	duplicates, err = user.GetDuplicateLinks()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(duplicates) != 2 || duplicates[0].ID != lower.ID {
+		t.Errorf("GetDuplicateLinks returned %d links, want #%d and its duplicate", len(duplicates), lower.ID)
+	}
+}
+
+func TestUpdateCrawlResultAfterURLChange(t *testing.T) {
+	db := openTestDB(t)
+	user := db.NewUser(t.Name(), "password")
+	link := insertTestLink(t, user, "https://example.com/old")
+
+	// The crawler loads the link, and then the user changes the URL while the page is being fetched.
+	crawled := user.GetLink(link.ID)
+	edited := user.GetLink(link.ID)
+	edited.URL, _ = url.Parse("https://example.com/new")
+	edited.CrawlStatus = CrawlPending
+	edited.NormalizeURL()
+	err := edited.UpdateFields(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crawled.Title = "Old page"
+	crawled.CrawlStatus = CrawlDone
+	crawled.SetHealth(200, "", true)
+	stored, err := crawled.UpdateCrawlResult()
+	if err != nil {
+		t.Fatal(err)
+	} else if stored {
+		t.Error("UpdateCrawlResult stored the result of the old URL")
+	}
+	err = crawled.UpdateHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := user.GetLink(link.ID)
+	if current.URL.String() != "https://example.com/new" || current.CrawlStatus != CrawlPending ||
+		current.Title == "Old page" || current.NormalizedURL != edited.NormalizedURL || current.HealthStatus != 0 {
+		t.Errorf("Stale crawl result overwrote the edited link: %+v", current)
+	}
+
+	current.Title = "New page"
+	current.CrawlStatus = CrawlDone
+	stored, err = current.UpdateCrawlResult()
+	if err != nil {
+		t.Fatal(err)
+	} else if !stored {
+		t.Error("UpdateCrawlResult didn't store the result of the current URL")
+	} else if title := user.GetLink(link.ID).Title; title != "New page" {
+		t.Errorf("Title is %q after storing crawl result, want %q", title, "New page")
+	}
+}
+
+func TestQueryLinksRequiredTags(t *testing.T) {
+	db := openTestDB(t)
+	user := db.NewUser(t.Name(), "password")
+	links := map[string]*Link{}
+	for name, tags := range map[string][]string{
+		"a":   {"a"},
+		"b":   {"b"},
+		"a+c": {"a", "c"},
+		"b+c": {"b", "c"},
+		"c":   {"c"},
+	} {
+		link := insertTestLink(t, user, "https://example.com/"+name)
+		err := link.UpdateTags(tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[name] = link
+	}
+
+	tests := []struct {
+		name   string
+		filter LinkFilter
+		want   []string
+	}{
+		{"any", LinkFilter{Tags: []string{"a", "b"}}, []string{"a", "b", "a+c", "b+c"}},
+		{"any and required", LinkFilter{Tags: []string{"a", "b"}, RequiredTags: []string{"c"}}, []string{"a+c", "b+c"}},
+		{"all and required", LinkFilter{Tags: []string{"a", "b"}, ExclusiveTags: true, RequiredTags: []string{"c"}},
+			nil},
+		{"only required", LinkFilter{RequiredTags: []string{"a", "c"}}, []string{"a+c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.filter.Order = OrderOldest
+			got, err := user.QueryLinks(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[int]bool{}
+			for _, name := range test.want {
+				want[links[name].ID] = true
+			}
+			if len(got) != len(want) {
+				t.Errorf("QueryLinks returned %d links, want %v", len(got), test.want)
+			}
+			for _, link := range got {
+				if !want[link.ID] {
+					t.Errorf("QueryLinks returned unexpected link %s", link.URL)
+				}
+			}
+		})
+	}
+}
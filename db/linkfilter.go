@@ -0,0 +1,152 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// LinkOrder is the order in which links are listed.
+type LinkOrder string
+
+const (
+	OrderNewest LinkOrder = "newest"
+	OrderOldest LinkOrder = "oldest"
+	OrderTitle  LinkOrder = "title"
+	OrderDomain LinkOrder = "domain"
+)
+
+// LinkHealth is a result of the link health checks that links can be filtered by.
+type LinkHealth string
+
+const (
+	// HealthBroken means that the latest health check of the link failed.
+	HealthBroken LinkHealth = "broken"
+	// HealthRedirected means that the link redirected to another URL in the latest health check.
+	HealthRedirected LinkHealth = "redirected"
+)
+
+// LinkFilter contains the filters, order and pagination used when listing links.
+type LinkFilter struct {
+	// Tags limits the links to ones that have any of the tags, or all of them if ExclusiveTags is true.
+	Tags          []string
+	ExclusiveTags bool
+	// RequiredTags limits the links to ones that have all of the tags, regardless of ExclusiveTags.
+	RequiredTags []string
+	// ExcludedTags limits the links to ones that have none of the tags.
+	ExcludedTags []string
+	// Domains limits the links to ones that are in any of the domains.
+	Domains         []string
+	ExcludedDomains []string
+	// Title limits the links to ones whose title contains all of the strings.
+	Title []string
+	// Read limits the links to ones that have (true) or have not (false) been marked as read if it's not nil.
+	Read *bool
+	// Since and Until limit the links to ones with a timestamp in the range. Zero means no limit.
+	Since int64
+	Until int64
+	// Health limits the links to ones with the given health check result if it's not empty.
+	Health LinkHealth
+
+	Order LinkOrder
+	// Limit is the maximum number of links to return. Zero means no limit.
+	Limit int
+	// Offset is the number of links to skip. Ignored if After is set.
+	Offset int
+	// After is the position of the last link on the previous page. If set, only links after it are returned.
+	After *LinkCursor
+}
+
+// LinkCursor is the position of a link in a listing. It's used to fetch the next page without having to skip over
+// all the previous links like an offset does.
+type LinkCursor struct {
+	ID    int
+	Value string
+}
+
+// ErrInvalidCursor is returned by ParseLinkCursor if the cursor is malformed.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor gets the position of this link in a listing with the given order.
+func (link *Link) Cursor(order LinkOrder) LinkCursor {
+	cursor := LinkCursor{ID: link.ID}
+	switch order {
+	case OrderTitle:
+		cursor.Value = strings.ToLower(link.Title)
+	case OrderDomain:
+		cursor.Value = link.URL.Hostname()
+	default:
+		cursor.Value = strconv.FormatInt(link.Timestamp, 10)
+	}
+	return cursor
+}
+
+// String encodes the cursor into an opaque string that can be given to clients.
+func (cursor LinkCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(cursor.ID) + ":" + cursor.Value))
+}
+
+// ParseLinkCursor decodes a cursor created with LinkCursor.String().
+func ParseLinkCursor(str string) (*LinkCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &LinkCursor{ID: id, Value: parts[1]}, nil
+}
+
+// QueryLinks gets the links of this user that match the given filter.
+func (user *User) QueryLinks(filter LinkFilter) ([]*Link, error) {
+	return user.DB.Storage.QueryLinks(user, filter)
+}
+
+// CountLinks counts the links of this user that match the given filter. The order and pagination are ignored.
+func (user *User) CountLinks(filter LinkFilter) (int, error) {
+	return user.DB.Storage.CountLinks(user, filter)
+}
+
+// FacetCount is the number of links that have a specific tag, domain or month.
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// LinkFacets contains the distribution of the links that match a filter.
+type LinkFacets struct {
+	// Tags and Domains contain the most common values, most common first.
+	Tags    []FacetCount
+	Domains []FacetCount
+	// Months contains the number of links per month (YYYY-MM, UTC) in chronological order. Empty months are omitted.
+	Months []FacetCount
+}
+
+// GetLinkFacets counts the links of this user that match the given filter per tag, domain and month. At most size
+// tags and domains are returned. The order and pagination of the filter are ignored.
+func (user *User) GetLinkFacets(filter LinkFilter, size int) (*LinkFacets, error) {
+	return user.DB.Storage.GetLinkFacets(user, filter, size)
+}
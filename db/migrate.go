@@ -0,0 +1,189 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// LatestVersion is the newest schema version this build knows about.
+var LatestVersion = len(Migrations)
+
+// SchemaTooNewError is returned when the database has been migrated by a newer version of lindeb.
+type SchemaTooNewError struct {
+	Version int
+}
+
+func (err SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the latest version supported by this build (%d)",
+		err.Version, LatestVersion)
+}
+
+// AppliedMigration contains the info stored about a migration that has been applied.
+type AppliedMigration struct {
+	Version     int
+	Description string
+	Timestamp   int64
+}
+
+func (db *DB) createVersionTable() (err error) {
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS SchemaVersion (
+		version     INTEGER      PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		timestamp   BIGINT       NOT NULL
+	)`)
+	return
+}
+
+// SchemaVersion gets the current schema version of the database. Zero means that no migrations have been applied.
+func (db *DB) SchemaVersion() (version int, err error) {
+	err = db.createVersionTable()
+	if err != nil {
+		return
+	}
+	err = db.QueryRow("SELECT IFNULL(MAX(version), 0) FROM SchemaVersion").Scan(&version)
+	return
+}
+
+// AppliedMigrations gets the list of migrations that have been applied to the database.
+func (db *DB) AppliedMigrations() ([]AppliedMigration, error) {
+	err := db.createVersionTable()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, description, timestamp FROM SchemaVersion ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var applied []AppliedMigration
+	for rows.Next() {
+		var migration AppliedMigration
+		err = rows.Scan(&migration.Version, &migration.Description, &migration.Timestamp)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, rows.Err()
+}
+
+// Upgrade applies all the migrations that have not been applied yet.
+//
+// If the database schema is newer than this build supports, a SchemaTooNewError is returned and nothing is changed.
+func (db *DB) Upgrade() error {
+	return db.MigrateTo(LatestVersion)
+}
+
+// MigrateTo applies or reverts migrations until the schema is at the given version.
+//
+// Each migration is run in its own transaction. MySQL commits implicitly after most schema changes, so rolling back
+// doesn't undo them there. If converting the data of a migration fails on MySQL, the down queries of the migration are
+// run to undo the schema changes so that it can be retried. If one of the queries fails instead, the queries before it
+// may have to be reverted manually.
+func (db *DB) MigrateTo(target int) error {
+	if target < 0 || target > LatestVersion {
+		return fmt.Errorf("invalid target version %d", target)
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	} else if version > LatestVersion {
+		return SchemaTooNewError{version}
+	}
+	for revert := version; revert > target; revert-- {
+		if Migrations[revert-1].Irreversible {
+			return fmt.Errorf("migration v%d can't be reverted, so the oldest version to migrate to is v%d",
+				revert, revert)
+		}
+	}
+
+	for ; version < target; version++ {
+		err = db.runMigration(version+1, true)
+		if err != nil {
+			return err
+		}
+	}
+	for ; version > target; version-- {
+		err = db.runMigration(version, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) runMigration(version int, up bool) error {
+	migration := Migrations[version-1]
+	queries := migration.Down
+	if up {
+		fmt.Printf("Migrating database to v%d: %s\n", version, migration.Description)
+		queries = migration.Up
+	} else {
+		fmt.Printf("Reverting database migration v%d: %s\n", version, migration.Description)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range queries.For(db.Type) {
+		_, err = tx.Exec(query)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration v%d failed: %v", version, err)
+		}
+	}
+	if up && migration.Convert != nil {
+		err = migration.Convert(tx)
+		if err != nil {
+			tx.Rollback()
+			db.undoSchemaChanges(migration)
+			return fmt.Errorf("migration v%d failed to convert data: %v", version, err)
+		}
+	}
+	if up {
+		_, err = tx.Exec("INSERT INTO SchemaVersion (version, description, timestamp) VALUES (?, ?, ?)",
+			version, migration.Description, time.Now().Unix())
+	} else {
+		_, err = tx.Exec("DELETE FROM SchemaVersion WHERE version=?", version)
+	}
+	if err != nil {
+		tx.Rollback()
+		if up {
+			db.undoSchemaChanges(migration)
+		}
+		return fmt.Errorf("failed to store schema version: %v", err)
+	}
+	return tx.Commit()
+}
+
+// undoSchemaChanges reverts the schema changes of a migration whose up queries all succeeded, but which failed
+// afterwards. It's only needed on MySQL, where schema changes are committed implicitly and can't be rolled back.
+func (db *DB) undoSchemaChanges(migration Migration) {
+	if db.Type != "mysql" {
+		return
+	}
+	for _, query := range migration.Down.For(db.Type) {
+		_, err := db.Exec(query)
+		if err != nil {
+			fmt.Printf("Failed to undo changes of failed migration: %v\n", err)
+		}
+	}
+}
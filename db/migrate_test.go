@@ -0,0 +1,65 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"testing"
+)
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openTestDB(t)
+	user := db.NewUser(t.Name(), "password")
+	insertTestLink(t, user, "https://example.com/page")
+
+	err := db.MigrateTo(1)
+	if err != nil {
+		t.Fatalf("Failed to revert migrations: %v", err)
+	}
+	err = db.Upgrade()
+	if err != nil {
+		t.Fatalf("Failed to apply migrations again: %v", err)
+	}
+	links, err := user.GetLinks()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(links) != 1 || links[0].NormalizedURL != "https://example.com/page" {
+		t.Errorf("Links after migrating down and up = %+v, want the inserted link", links)
+	}
+}
+
+func TestInitialMigrationIsIrreversible(t *testing.T) {
+	db := openTestDB(t)
+	user := db.NewUser(t.Name(), "password")
+	insertTestLink(t, user, "https://example.com/page")
+
+	err := db.MigrateTo(0)
+	if err == nil {
+		t.Fatal("Migrating to v0 succeeded")
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	} else if version != LatestVersion {
+		t.Errorf("Schema version is %d after refused migration, want %d", version, LatestVersion)
+	}
+	links, err := user.GetLinks()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(links) != 1 {
+		t.Errorf("Got %d links after refused migration, want 1", len(links))
+	}
+}
@@ -0,0 +1,448 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"database/sql"
+	"net/url"
+
+	"maunium.net/go/lindeb/util"
+)
+
+// Queries is a list of SQL statements for each database type.
+//
+// If the list for the current database type is empty, the Common list is used instead.
+type Queries struct {
+	Common []string
+	MySQL  []string
+	SQLite []string
+}
+
+// For returns the statements for the given database type.
+func (q Queries) For(dbType string) []string {
+	switch {
+	case dbType == "mysql" && len(q.MySQL) > 0:
+		return q.MySQL
+	case dbType == "sqlite" && len(q.SQLite) > 0:
+		return q.SQLite
+	default:
+		return q.Common
+	}
+}
+
+// Migration is a single change to the database schema.
+type Migration struct {
+	Description string
+	Up          Queries
+	Down        Queries
+	// Irreversible means that the migration can't be reverted, so the database can't be migrated to any earlier
+	// version.
+	Irreversible bool
+	// Convert is run after the Up queries in the same transaction. It's used for converting existing data when that
+	// can't be done in SQL.
+	Convert func(tx *sql.Tx) error
+}
+
+// Migrations contains all the schema migrations in the order they must be applied.
+//
+// The version of a migration is its index in this list plus one. Never reorder or remove migrations, only append
+// new ones to the end.
+var Migrations = []Migration{{
+	Description: "Initial schema",
+	// The initial tables use IF NOT EXISTS, as databases created before migrations existed already have them.
+	Up: Queries{
+		MySQL: []string{
+			`CREATE TABLE IF NOT EXISTS User (
+				id       INTEGER     PRIMARY KEY AUTO_INCREMENT,
+				username VARCHAR(32) NOT NULL UNIQUE,
+				password VARCHAR(60) NOT NULL
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+			`CREATE TABLE IF NOT EXISTS AuthToken (
+				user  INTEGER,
+				token VARCHAR(64),
+				PRIMARY KEY (user, token),
+				FOREIGN KEY (user) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+			`CREATE TABLE IF NOT EXISTS Setting (
+				user  INTEGER,
+				vkey  VARCHAR(32),
+				value TEXT,
+
+				PRIMARY KEY (user, vkey),
+				FOREIGN KEY (user) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+			`CREATE TABLE IF NOT EXISTS Link (
+				id          INTEGER       PRIMARY KEY AUTO_INCREMENT,
+				url         VARCHAR(2047) NOT NULL,
+				domain      VARCHAR(255)  NOT NULL,
+				title       VARCHAR(255)  NOT NULL,
+				description TEXT          NOT NULL,
+				timestamp   BIGINT        NOT NULL,
+				owner       INTEGER       NOT NULL,
+
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+			`CREATE TABLE IF NOT EXISTS Tag (
+				id          INTEGER     PRIMARY KEY AUTO_INCREMENT,
+				name        VARCHAR(32) NOT NULL,
+				description TEXT        NOT NULL,
+				owner       INTEGER     NOT NULL,
+
+				UNIQUE KEY name (name, owner),
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+			`CREATE TABLE IF NOT EXISTS LinkTag (
+				link INTEGER NOT NULL,
+				tag  INTEGER NOT NULL,
+
+				UNIQUE KEY linktag (link, tag),
+				FOREIGN KEY (link) REFERENCES Link(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT,
+				FOREIGN KEY (tag)  REFERENCES Tag(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+		},
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS User (
+				id       INTEGER     PRIMARY KEY AUTOINCREMENT,
+				username VARCHAR(32) NOT NULL UNIQUE COLLATE NOCASE,
+				password VARCHAR(60) NOT NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS AuthToken (
+				user  INTEGER,
+				token VARCHAR(64),
+				PRIMARY KEY (user, token),
+				FOREIGN KEY (user) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			);`,
+			`CREATE TABLE IF NOT EXISTS Setting (
+				user  INTEGER,
+				vkey  VARCHAR(32),
+				value TEXT,
+
+				PRIMARY KEY (user, vkey),
+				FOREIGN KEY (user) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			);`,
+			`CREATE TABLE IF NOT EXISTS Link (
+				id          INTEGER       PRIMARY KEY AUTOINCREMENT,
+				url         VARCHAR(2047) NOT NULL,
+				domain      VARCHAR(255)  NOT NULL,
+				title       VARCHAR(255)  NOT NULL,
+				description TEXT          NOT NULL,
+				timestamp   BIGINT        NOT NULL,
+				owner       INTEGER       NOT NULL,
+
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			);`,
+			`CREATE TABLE IF NOT EXISTS Tag (
+				id          INTEGER     PRIMARY KEY AUTOINCREMENT,
+				name        VARCHAR(32) NOT NULL COLLATE NOCASE,
+				description TEXT        NOT NULL,
+				owner       INTEGER     NOT NULL,
+
+				UNIQUE (name, owner),
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			);`,
+			`CREATE TABLE IF NOT EXISTS LinkTag (
+				link INTEGER NOT NULL,
+				tag  INTEGER NOT NULL,
+
+				UNIQUE (link, tag),
+				FOREIGN KEY (link) REFERENCES Link(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT,
+				FOREIGN KEY (tag)  REFERENCES Tag(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			);`,
+		},
+	},
+	// The tables may have existed before this migration, so dropping them could delete the data of an upgraded
+	// installation.
+	Irreversible: true,
+}, {
+	Description: "Add background job queue",
+	Up: Queries{
+		MySQL: []string{
+			`CREATE TABLE Job (
+				id         INTEGER     PRIMARY KEY AUTO_INCREMENT,
+				type       VARCHAR(16) NOT NULL,
+				owner      INTEGER     NOT NULL,
+				link       INTEGER     NOT NULL,
+				payload    MEDIUMTEXT  NOT NULL,
+				status     VARCHAR(16) NOT NULL,
+				attempts   INTEGER     NOT NULL DEFAULT 0,
+				last_error TEXT        NOT NULL,
+				run_at     BIGINT      NOT NULL,
+				created_at BIGINT      NOT NULL,
+				updated_at BIGINT      NOT NULL,
+
+				INDEX job_queue (status, run_at),
+				INDEX job_link (owner, link),
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+		},
+		SQLite: []string{
+			`CREATE TABLE Job (
+				id         INTEGER     PRIMARY KEY AUTOINCREMENT,
+				type       VARCHAR(16) NOT NULL,
+				owner      INTEGER     NOT NULL,
+				link       INTEGER     NOT NULL,
+				payload    TEXT        NOT NULL,
+				status     VARCHAR(16) NOT NULL,
+				attempts   INTEGER     NOT NULL DEFAULT 0,
+				last_error TEXT        NOT NULL,
+				run_at     BIGINT      NOT NULL,
+				created_at BIGINT      NOT NULL,
+				updated_at BIGINT      NOT NULL,
+
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			);`,
+			"CREATE INDEX job_queue ON Job (status, run_at);",
+			"CREATE INDEX job_link ON Job (owner, link);",
+		},
+	},
+	Down: Queries{
+		Common: []string{"DROP TABLE Job"},
+	},
+}, {
+	Description: "Add read state to links",
+	Up: Queries{
+		Common: []string{"ALTER TABLE Link ADD COLUMN is_read BOOLEAN NOT NULL DEFAULT 0"},
+	},
+	Down: Queries{
+		Common: []string{"ALTER TABLE Link DROP COLUMN is_read"},
+	},
+}, {
+	Description: "Add saved searches",
+	Up: Queries{
+		MySQL: []string{
+			`CREATE TABLE SavedSearch (
+				id             INTEGER      PRIMARY KEY AUTO_INCREMENT,
+				owner          INTEGER      NOT NULL,
+				name           VARCHAR(255) NOT NULL,
+				search_text    TEXT         NOT NULL,
+				tags           TEXT         NOT NULL,
+				domains        TEXT         NOT NULL,
+				exclusive_tags BOOLEAN      NOT NULL DEFAULT 0,
+				sort_order     VARCHAR(16)  NOT NULL,
+				checked_at     BIGINT       NOT NULL,
+				created_at     BIGINT       NOT NULL,
+
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+		},
+		SQLite: []string{
+			`CREATE TABLE SavedSearch (
+				id             INTEGER      PRIMARY KEY AUTOINCREMENT,
+				owner          INTEGER      NOT NULL,
+				name           VARCHAR(255) NOT NULL,
+				search_text    TEXT         NOT NULL,
+				tags           TEXT         NOT NULL,
+				domains        TEXT         NOT NULL,
+				exclusive_tags BOOLEAN      NOT NULL DEFAULT 0,
+				sort_order     VARCHAR(16)  NOT NULL,
+				checked_at     BIGINT       NOT NULL,
+				created_at     BIGINT       NOT NULL,
+
+				FOREIGN KEY (owner) REFERENCES User(id)
+					ON DELETE CASCADE ON UPDATE RESTRICT
+			);`,
+		},
+	},
+	Down: Queries{
+		Common: []string{"DROP TABLE SavedSearch"},
+	},
+}, {
+	Description: "Add crawl state to links",
+	Up: Queries{
+		// Links saved before this were crawled while saving, so they're marked as done.
+		Common: []string{
+			"ALTER TABLE Link ADD COLUMN custom_title BOOLEAN NOT NULL DEFAULT 0",
+			"ALTER TABLE Link ADD COLUMN custom_description BOOLEAN NOT NULL DEFAULT 0",
+			"ALTER TABLE Link ADD COLUMN crawl_status VARCHAR(16) NOT NULL DEFAULT 'done'",
+			"ALTER TABLE Link ADD COLUMN crawled_at BIGINT NOT NULL DEFAULT 0",
+			"ALTER TABLE Link ADD COLUMN http_status INTEGER NOT NULL DEFAULT 0",
+		},
+	},
+	Down: Queries{
+		Common: []string{
+			"ALTER TABLE Link DROP COLUMN custom_title",
+			"ALTER TABLE Link DROP COLUMN custom_description",
+			"ALTER TABLE Link DROP COLUMN crawl_status",
+			"ALTER TABLE Link DROP COLUMN crawled_at",
+			"ALTER TABLE Link DROP COLUMN http_status",
+		},
+	},
+}, {
+	Description: "Add article data to links",
+	Up: Queries{
+		Common: []string{
+			"ALTER TABLE Link ADD COLUMN word_count INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE Link ADD COLUMN language VARCHAR(16) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN author VARCHAR(255) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN published_at BIGINT NOT NULL DEFAULT 0",
+		},
+	},
+	Down: Queries{
+		Common: []string{
+			"ALTER TABLE Link DROP COLUMN word_count",
+			"ALTER TABLE Link DROP COLUMN language",
+			"ALTER TABLE Link DROP COLUMN author",
+			"ALTER TABLE Link DROP COLUMN published_at",
+		},
+	},
+}, {
+	Description: "Add page metadata to links",
+	Up: Queries{
+		Common: []string{
+			"ALTER TABLE Link ADD COLUMN canonical_url VARCHAR(2047) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN image_url VARCHAR(2047) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN site_name VARCHAR(255) NOT NULL DEFAULT ''",
+		},
+	},
+	Down: Queries{
+		Common: []string{
+			"ALTER TABLE Link DROP COLUMN canonical_url",
+			"ALTER TABLE Link DROP COLUMN image_url",
+			"ALTER TABLE Link DROP COLUMN site_name",
+		},
+	},
+}, {
+	Description: "Add link health checks",
+	Up: Queries{
+		Common: []string{
+			"ALTER TABLE Link ADD COLUMN health_status INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE Link ADD COLUMN final_url VARCHAR(2047) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN health_checked_at BIGINT NOT NULL DEFAULT 0",
+			"ALTER TABLE Link ADD COLUMN health_failures INTEGER NOT NULL DEFAULT 0",
+			"CREATE INDEX link_health_check ON Link (owner, health_checked_at)",
+		},
+	},
+	Down: Queries{
+		MySQL: []string{
+			"DROP INDEX link_health_check ON Link",
+			"ALTER TABLE Link DROP COLUMN health_status",
+			"ALTER TABLE Link DROP COLUMN final_url",
+			"ALTER TABLE Link DROP COLUMN health_checked_at",
+			"ALTER TABLE Link DROP COLUMN health_failures",
+		},
+		SQLite: []string{
+			"DROP INDEX link_health_check",
+			"ALTER TABLE Link DROP COLUMN health_status",
+			"ALTER TABLE Link DROP COLUMN final_url",
+			"ALTER TABLE Link DROP COLUMN health_checked_at",
+			"ALTER TABLE Link DROP COLUMN health_failures",
+		},
+	},
+}, {
+	Description: "Add page archives to links",
+	Up: Queries{
+		Common: []string{
+			"ALTER TABLE Link ADD COLUMN archive_hash VARCHAR(64) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN archive_type VARCHAR(127) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN archived_at BIGINT NOT NULL DEFAULT 0",
+		},
+	},
+	Down: Queries{
+		Common: []string{
+			"ALTER TABLE Link DROP COLUMN archive_hash",
+			"ALTER TABLE Link DROP COLUMN archive_type",
+			"ALTER TABLE Link DROP COLUMN archived_at",
+		},
+	},
+}, {
+	Description: "Add cached favicons and thumbnails to links",
+	Up: Queries{
+		Common: []string{
+			"ALTER TABLE Link ADD COLUMN favicon_hash VARCHAR(64) NOT NULL DEFAULT ''",
+			"ALTER TABLE Link ADD COLUMN thumbnail_hash VARCHAR(64) NOT NULL DEFAULT ''",
+		},
+	},
+	Down: Queries{
+		Common: []string{
+			"ALTER TABLE Link DROP COLUMN favicon_hash",
+			"ALTER TABLE Link DROP COLUMN thumbnail_hash",
+		},
+	},
+}, {
+	Description: "Add normalized URLs to links",
+	Up: Queries{
+		// MySQL can only index the beginning of long text columns. The column is compared byte by byte, as the paths
+		// and queries of URLs are case-sensitive.
+		MySQL: []string{
+			"ALTER TABLE Link ADD COLUMN normalized_url VARCHAR(2047) COLLATE utf8mb4_bin NOT NULL DEFAULT ''",
+			"CREATE INDEX link_normalized_url ON Link (owner, normalized_url(255))",
+		},
+		SQLite: []string{
+			"ALTER TABLE Link ADD COLUMN normalized_url VARCHAR(2047) NOT NULL DEFAULT ''",
+			"CREATE INDEX link_normalized_url ON Link (owner, normalized_url)",
+		},
+	},
+	Down: Queries{
+		MySQL: []string{
+			"DROP INDEX link_normalized_url ON Link",
+			"ALTER TABLE Link DROP COLUMN normalized_url",
+		},
+		SQLite: []string{
+			"DROP INDEX link_normalized_url",
+			"ALTER TABLE Link DROP COLUMN normalized_url",
+		},
+	},
+	Convert: normalizeLinkURLs,
+}}
+
+// normalizeLinkURLs fills in the normalized URLs of existing links.
+func normalizeLinkURLs(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, url FROM Link")
+	if err != nil {
+		return err
+	}
+	normalized := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var urlString string
+		err = rows.Scan(&id, &urlString)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		// Unparseable URLs are left without a normalized URL, so they're never considered duplicates.
+		if parsed, err := url.Parse(urlString); err == nil {
+			normalized[id] = util.NormalizeURL(parsed)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, normalizedURL := range normalized {
+		_, err = tx.Exec("UPDATE Link SET normalized_url=? WHERE id=?", normalizedURL, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"database/sql"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlStorage is the MySQL/MariaDB storage backend.
+type mysqlStorage struct {
+	*sqlStorage
+}
+
+// connectMySQL connects to the MySQL database determined by the given go-sql-driver DSN.
+func connectMySQL(dsn string) (*DB, error) {
+	conf, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Params == nil {
+		conf.Params = make(map[string]string)
+	}
+	// Force utf8mb4 in the MySQL client as we use it when creating tables as well.
+	conf.Params["charset"] = "utf8mb4"
+
+	sqlDB, err := sql.Open("mysql", conf.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{DB: sqlDB, Type: "mysql"}
+	db.Storage = &mysqlStorage{&sqlStorage{db}}
+	return db, nil
+}
+
+func (s *mysqlStorage) SetSetting(user *User, key, value string) (err error) {
+	_, err = s.db.Exec(
+		"INSERT INTO Setting (user, vkey, value) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value=?",
+		user.ID, key, value, value)
+	return
+}
+
+func (s *mysqlStorage) GetLinkFacets(user *User, filter LinkFilter, size int) (*LinkFacets, error) {
+	// FROM_UNIXTIME uses the session time zone, so the result is converted to UTC to match the other backends.
+	return s.getLinkFacets(user, filter, size,
+		"DATE_FORMAT(CONVERT_TZ(FROM_UNIXTIME(Link.timestamp), @@session.time_zone, '+00:00'), '%Y-%m')")
+}
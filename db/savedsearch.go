@@ -0,0 +1,82 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"time"
+)
+
+// SavedSearch is a search query and filters for listing links that a user has stored to run again later.
+type SavedSearch struct {
+	DB    *DB   `json:"-"`
+	Owner *User `json:"-"`
+
+	ID            int      `json:"id"`
+	Name          string   `json:"name"`
+	Search        string   `json:"search"`
+	Tags          []string `json:"tags"`
+	Domains       []string `json:"domains"`
+	ExclusiveTags bool     `json:"exclusiveTags"`
+	Sort          string   `json:"sort"`
+	// CheckedAt is the time when new links of the search were last fetched.
+	CheckedAt int64 `json:"checkedAt"`
+	CreatedAt int64 `json:"createdAt"`
+}
+
+// BlankSavedSearch creates a blank saved search.
+func (user *User) BlankSavedSearch() *SavedSearch {
+	return &SavedSearch{
+		DB:    user.DB,
+		Owner: user,
+	}
+}
+
+// GetSavedSearch tries to find a saved search from the database, and returns nil if something goes wrong.
+func (user *User) GetSavedSearch(id int) (search *SavedSearch) {
+	search, _ = user.DB.Storage.GetSavedSearch(user, id)
+	return
+}
+
+// GetSavedSearches gets all the saved searches of this user.
+func (user *User) GetSavedSearches() ([]*SavedSearch, error) {
+	return user.DB.Storage.GetSavedSearches(user)
+}
+
+// Insert stores the data of this saved search into the database and fills in the ID field of the struct with the ID
+// of the inserted row.
+func (search *SavedSearch) Insert() error {
+	now := time.Now().Unix()
+	search.CreatedAt = now
+	search.CheckedAt = now
+	return search.DB.Storage.InsertSavedSearch(search)
+}
+
+// Update updates the data of this saved search in the database.
+func (search *SavedSearch) Update() error {
+	return search.DB.Storage.UpdateSavedSearch(search)
+}
+
+// MarkChecked sets the time when new links of the search were last fetched and stores it in the database.
+func (search *SavedSearch) MarkChecked(at time.Time) error {
+	search.CheckedAt = at.Unix()
+	return search.Update()
+}
+
+// Delete deletes this saved search from the database.
+func (search *SavedSearch) Delete() error {
+	return search.DB.Storage.DeleteSavedSearch(search)
+}
@@ -17,38 +17,18 @@
 package db
 
 func (user *User) GetSetting(key string) (value string) {
-	settingRow := user.DB.QueryRow("SELECT value FROM Setting WHERE vkey=? AND user=?", key, user.ID)
-	if settingRow != nil {
-		settingRow.Scan(&value)
-	}
+	value, _ = user.DB.Storage.GetSetting(user, key)
 	return
 }
 
 func (user *User) GetSettings() (map[string]string, error) {
-	results, err := user.DB.Query("SELECT vkey, value FROM Setting WHERE user=?", user.ID)
-	if err != nil {
-		return nil, err
-	}
-	values := make(map[string]string)
-	for results.Next() {
-		var key, value string
-		err = results.Scan(&key, &value)
-		if err != nil {
-			return nil, err
-		}
-		values[key] = value
-	}
-	return values, nil
+	return user.DB.Storage.GetSettings(user)
 }
 
-func (user *User) SetSetting(key, value string) (err error) {
-	_, err = user.DB.Exec(
-		"INSERT INTO Setting (user, vkey, value) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value=?",
-		user.ID, key, value, value)
-	return
+func (user *User) SetSetting(key, value string) error {
+	return user.DB.Storage.SetSetting(user, key, value)
 }
 
-func (user *User) DeleteSetting(key string) (err error) {
-	_, err = user.DB.Exec("DELETE FROM Setting WHERE user=? AND vkey=?", user.ID, key)
-	return
+func (user *User) DeleteSetting(key string) error {
+	return user.DB.Storage.DeleteSetting(user, key)
 }
@@ -0,0 +1,61 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStorage is the embedded SQLite storage backend.
+type sqliteStorage struct {
+	*sqlStorage
+}
+
+// connectSQLite opens the SQLite database at the given path. Driver options can be given as a query string after
+// the path, e.g. "/var/lib/lindeb/lindeb.db?_journal_mode=WAL".
+func connectSQLite(path string) (*DB, error) {
+	separator := "?"
+	if strings.ContainsRune(path, '?') {
+		separator = "&"
+	}
+	// SQLite doesn't enforce foreign keys by default, but the ON DELETE CASCADEs are needed for deleting things.
+	sqlDB, err := sql.Open("sqlite3", path+separator+"_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time anyway, and using a single connection means that
+	// in-memory databases work too.
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &DB{DB: sqlDB, Type: "sqlite"}
+	db.Storage = &sqliteStorage{&sqlStorage{db}}
+	return db, nil
+}
+
+func (s *sqliteStorage) SetSetting(user *User, key, value string) (err error) {
+	_, err = s.db.Exec(
+		"INSERT INTO Setting (user, vkey, value) VALUES (?, ?, ?) ON CONFLICT (user, vkey) DO UPDATE SET value=?",
+		user.ID, key, value, value)
+	return
+}
+
+func (s *sqliteStorage) GetLinkFacets(user *User, filter LinkFilter, size int) (*LinkFacets, error) {
+	return s.getLinkFacets(user, filter, size, "strftime('%Y-%m', Link.timestamp, 'unixepoch')")
+}
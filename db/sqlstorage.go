@@ -0,0 +1,941 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// sqlStorage contains the queries that are shared by all the SQL backends.
+//
+// The backend-specific storages embed this and override the methods that need features not in the common subset
+// of MySQL and SQLite.
+type sqlStorage struct {
+	db *DB
+}
+
+// hashToken hashes an auth token for storage. The result is the same as MySQL's SHA2(token, 256), so tokens stored
+// before the hashing was moved out of the database are still valid.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+const userColumns = "User.id, User.username, User.password"
+
+func (s *sqlStorage) scanUser(row Scannable) (*User, error) {
+	var scanID int
+	var username, passwordHash string
+	err := row.Scan(&scanID, &username, &passwordHash)
+	if err != nil {
+		return nil, err
+	}
+	return &User{
+		DB:           s.db,
+		ID:           scanID,
+		Username:     username,
+		PasswordHash: []byte(passwordHash),
+	}, nil
+}
+
+func (s *sqlStorage) GetUser(id int) (*User, error) {
+	return s.scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM User WHERE id=?", id))
+}
+
+func (s *sqlStorage) GetUserByName(name string) (*User, error) {
+	return s.scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM User WHERE username=?", name))
+}
+
+func (s *sqlStorage) GetUsers() ([]*User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM User ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []*User
+	for rows.Next() {
+		user, err := s.scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStorage) InsertUser(user *User) error {
+	result, err := s.db.Exec(
+		"INSERT INTO User (username, password) VALUES (?, ?)",
+		user.Username, string(user.PasswordHash))
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.ID = int(id)
+	return nil
+}
+
+func (s *sqlStorage) UpdateUser(user *User) (err error) {
+	_, err = s.db.Exec(
+		"UPDATE User SET username=?,password=? WHERE id=?",
+		user.Username, string(user.PasswordHash), user.ID)
+	return
+}
+
+func (s *sqlStorage) HasAuthToken(user *User, token string) (bool, error) {
+	var hashedToken string
+	err := s.db.QueryRow("SELECT token FROM AuthToken WHERE token=? AND user=?", hashToken(token), user.ID).
+		Scan(&hashedToken)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return len(hashedToken) > 0, nil
+}
+
+func (s *sqlStorage) InsertAuthToken(at *AuthToken) (err error) {
+	_, err = s.db.Exec("INSERT INTO AuthToken (user, token) VALUES (?, ?)", at.User.ID, hashToken(at.Token))
+	return
+}
+
+func (s *sqlStorage) DeleteAuthToken(at *AuthToken) (err error) {
+	_, err = s.db.Exec("DELETE FROM AuthToken WHERE token=? AND user=?", hashToken(at.Token), at.User.ID)
+	return
+}
+
+func (s *sqlStorage) DeleteAuthTokens(user *User) (err error) {
+	_, err = s.db.Exec("DELETE FROM AuthToken WHERE user=?", user.ID)
+	return
+}
+
+// linkColumns is the list of columns scanLink expects. The tags are added separately, as they need to be
+// aggregated from the LinkTag table. Both MySQL and SQLite have GROUP_CONCAT with a comma as the default separator.
+const linkColumns = "Link.id, Link.url, Link.domain, Link.title, Link.description, Link.timestamp, Link.owner, " +
+	"Link.is_read, Link.custom_title, Link.custom_description, Link.crawl_status, Link.crawled_at, " +
+	"Link.http_status, Link.word_count, Link.language, Link.author, Link.published_at, Link.canonical_url, " +
+	"Link.image_url, Link.site_name, Link.health_status, Link.final_url, Link.health_checked_at, " +
+	"Link.health_failures, Link.archive_hash, Link.archive_type, Link.archived_at, " +
+	"Link.favicon_hash, Link.thumbnail_hash, Link.normalized_url"
+
+// scanLink scans a database row into a Link object.
+func (s *sqlStorage) scanLink(user *User, row Scannable) (*Link, error) {
+	link := &Link{
+		DB:    s.db,
+		Owner: user,
+	}
+	var ownerID int
+	var urlString, domain, tagsString string
+	err := row.Scan(&link.ID, &urlString, &domain, &link.Title, &link.Description, &link.Timestamp, &ownerID,
+		&link.Read, &link.CustomTitle, &link.CustomDescription, &link.CrawlStatus, &link.CrawledAt, &link.HTTPStatus,
+		&link.WordCount, &link.Language, &link.Author, &link.PublishedAt, &link.CanonicalURL, &link.ImageURL,
+		&link.SiteName, &link.HealthStatus, &link.FinalURL, &link.HealthCheckedAt, &link.HealthFailures,
+		&link.ArchiveHash, &link.ArchiveType, &link.ArchivedAt, &link.FaviconHash, &link.ThumbnailHash,
+		&link.NormalizedURL, &tagsString)
+	if err != nil {
+		return nil, err
+	}
+	link.URL, err = url.Parse(urlString)
+	if err != nil {
+		return nil, err
+	}
+	if len(tagsString) > 0 {
+		link.Tags = strings.Split(tagsString, ",")
+	}
+	return link, nil
+}
+
+// scanLinks scans multiple database rows into an array of Links.
+func (s *sqlStorage) scanLinks(user *User, rows *sql.Rows) ([]*Link, error) {
+	defer rows.Close()
+	var links []*Link
+	for rows.Next() {
+		link, err := s.scanLink(user, rows)
+		if err != nil {
+			return links, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *sqlStorage) GetLink(user *User, id int) (*Link, error) {
+	return s.scanLink(user, s.db.QueryRow(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE Link.id=? AND Link.owner=?
+		GROUP BY Link.id`, id, user.ID))
+}
+
+func (s *sqlStorage) GetLinks(user *User) ([]*Link, error) {
+	results, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE Link.owner = ?
+		GROUP BY Link.id ORDER BY Link.id DESC`, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanLinks(user, results)
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// escapeLike escapes the wildcard characters in a string to be used in a LIKE pattern with ESCAPE '!'.
+func escapeLike(str string) string {
+	return likeEscaper.Replace(str)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// scanStrings scans database rows that contain a single string column.
+func scanStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	strs := []string{}
+	for rows.Next() {
+		var str string
+		err := rows.Scan(&str)
+		if err != nil {
+			return strs, err
+		}
+		strs = append(strs, str)
+	}
+	return strs, rows.Err()
+}
+
+func (s *sqlStorage) SuggestLinkTitles(user *User, prefix string, limit int) ([]*Link, error) {
+	prefix = escapeLike(strings.ToLower(prefix))
+	results, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE Link.owner=? AND (LOWER(Link.title) LIKE ? ESCAPE '!' OR LOWER(Link.title) LIKE ? ESCAPE '!')
+		GROUP BY Link.id ORDER BY Link.timestamp DESC, Link.id DESC LIMIT ?`,
+		user.ID, prefix+"%", "% "+prefix+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanLinks(user, results)
+}
+
+func (s *sqlStorage) SuggestDomains(user *User, prefix string, limit int) ([]string, error) {
+	prefix = escapeLike(strings.ToLower(prefix))
+	results, err := s.db.Query(`SELECT LOWER(domain) AS lower_domain FROM Link
+		WHERE owner=? AND (LOWER(domain) LIKE ? ESCAPE '!' OR LOWER(domain) LIKE ? ESCAPE '!')
+		GROUP BY lower_domain ORDER BY COUNT(*) DESC, lower_domain LIMIT ?`,
+		user.ID, prefix+"%", "www."+prefix+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(results)
+}
+
+// extraScanner scans additional columns after the ones requested by the wrapped scan function.
+type extraScanner struct {
+	Scannable
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.Scannable.Scan(append(dest, s.extra...)...)
+}
+
+func (s *sqlStorage) GetRelatedLinks(link *Link, limit int) ([]*RelatedLink, error) {
+	rows, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags,
+			(SELECT COUNT(*) FROM LinkTag AS Shared
+				WHERE Shared.link=Link.id AND Shared.tag IN (SELECT tag FROM LinkTag WHERE link=?))
+			+ CASE WHEN Link.domain=? AND Link.domain<>'' THEN 0.5 ELSE 0 END AS score
+		FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE Link.owner=? AND Link.id<>?
+		GROUP BY Link.id HAVING score>0 ORDER BY score DESC, Link.timestamp DESC LIMIT ?`,
+		link.ID, link.URL.Hostname(), link.Owner.ID, link.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var related []*RelatedLink
+	for rows.Next() {
+		relatedLink := &RelatedLink{}
+		relatedLink.Link, err = s.scanLink(link.Owner, extraScanner{rows, []interface{}{&relatedLink.Score}})
+		if err != nil {
+			return related, err
+		}
+		related = append(related, relatedLink)
+	}
+	return related, rows.Err()
+}
+
+// linkFilterConditions builds the WHERE clause for the given filter. Pagination is not included.
+func linkFilterConditions(user *User, filter LinkFilter) (string, []interface{}) {
+	conditions := []string{"Link.owner=?"}
+	args := []interface{}{user.ID}
+
+	if len(filter.Tags) > 0 {
+		tagArgs := make([]interface{}, len(filter.Tags))
+		for index, tag := range filter.Tags {
+			tagArgs[index] = tag
+		}
+		if filter.ExclusiveTags {
+			for _, tag := range tagArgs {
+				conditions = append(conditions, `EXISTS (SELECT 1 FROM LinkTag JOIN Tag ON Tag.id=LinkTag.tag
+					WHERE LinkTag.link=Link.id AND Tag.name=?)`)
+				args = append(args, tag)
+			}
+		} else {
+			conditions = append(conditions, `Link.id IN (SELECT LinkTag.link FROM LinkTag JOIN Tag ON Tag.id=LinkTag.tag
+				WHERE Tag.owner=? AND Tag.name IN (`+placeholders(len(tagArgs))+`))`)
+			args = append(append(args, user.ID), tagArgs...)
+		}
+	}
+	for _, tag := range filter.RequiredTags {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM LinkTag JOIN Tag ON Tag.id=LinkTag.tag
+			WHERE LinkTag.link=Link.id AND Tag.name=?)`)
+		args = append(args, tag)
+	}
+	for _, tag := range filter.ExcludedTags {
+		conditions = append(conditions, `NOT EXISTS (SELECT 1 FROM LinkTag JOIN Tag ON Tag.id=LinkTag.tag
+			WHERE LinkTag.link=Link.id AND Tag.name=?)`)
+		args = append(args, tag)
+	}
+	if len(filter.Domains) > 0 {
+		conditions = append(conditions, "Link.domain IN ("+placeholders(len(filter.Domains))+")")
+		for _, domain := range filter.Domains {
+			args = append(args, domain)
+		}
+	}
+	if len(filter.ExcludedDomains) > 0 {
+		conditions = append(conditions, "Link.domain NOT IN ("+placeholders(len(filter.ExcludedDomains))+")")
+		for _, domain := range filter.ExcludedDomains {
+			args = append(args, domain)
+		}
+	}
+	for _, title := range filter.Title {
+		conditions = append(conditions, "LOWER(Link.title) LIKE ? ESCAPE '!'")
+		args = append(args, "%"+escapeLike(strings.ToLower(title))+"%")
+	}
+	if filter.Read != nil {
+		conditions = append(conditions, "Link.is_read=?")
+		args = append(args, *filter.Read)
+	}
+	if filter.Since != 0 {
+		conditions = append(conditions, "Link.timestamp>=?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until != 0 {
+		conditions = append(conditions, "Link.timestamp<=?")
+		args = append(args, filter.Until)
+	}
+	switch filter.Health {
+	case HealthBroken:
+		conditions = append(conditions, "Link.health_failures>0")
+	case HealthRedirected:
+		conditions = append(conditions, "Link.health_failures=0 AND Link.final_url<>''")
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// linkOrderClauses contains the ORDER BY clause of each link order, and the condition that selects the links after a
+// cursor in that order.
+var linkOrderClauses = map[LinkOrder]struct{ order, after string }{
+	OrderNewest: {"Link.timestamp DESC, Link.id DESC", "(Link.timestamp<? OR (Link.timestamp=? AND Link.id<?))"},
+	OrderOldest: {"Link.timestamp ASC, Link.id ASC", "(Link.timestamp>? OR (Link.timestamp=? AND Link.id>?))"},
+	OrderTitle:  {"LOWER(Link.title) ASC, Link.id DESC", "(LOWER(Link.title)>? OR (LOWER(Link.title)=? AND Link.id<?))"},
+	OrderDomain: {"Link.domain ASC, Link.id DESC", "(Link.domain>? OR (Link.domain=? AND Link.id<?))"},
+}
+
+func (s *sqlStorage) QueryLinks(user *User, filter LinkFilter) ([]*Link, error) {
+	where, args := linkFilterConditions(user, filter)
+	clauses, ok := linkOrderClauses[filter.Order]
+	if !ok {
+		clauses = linkOrderClauses[OrderNewest]
+	}
+
+	if filter.After != nil {
+		where += " AND " + clauses.after
+		var value interface{} = filter.After.Value
+		if filter.Order != OrderTitle && filter.Order != OrderDomain {
+			timestamp, err := strconv.ParseInt(filter.After.Value, 10, 64)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			value = timestamp
+		}
+		args = append(args, value, value, filter.After.ID)
+	}
+
+	limit := ""
+	if filter.Limit > 0 {
+		limit = " LIMIT ?"
+		args = append(args, filter.Limit)
+		if filter.After == nil && filter.Offset > 0 {
+			limit += " OFFSET ?"
+			args = append(args, filter.Offset)
+		}
+	}
+
+	results, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE `+where+`
+		GROUP BY Link.id ORDER BY `+clauses.order+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanLinks(user, results)
+}
+
+func (s *sqlStorage) CountLinks(user *User, filter LinkFilter) (count int, err error) {
+	where, args := linkFilterConditions(user, filter)
+	err = s.db.QueryRow("SELECT COUNT(*) FROM Link WHERE "+where, args...).Scan(&count)
+	return
+}
+
+// getLinkFacets counts the links that match the filter. The month expression converts Link.timestamp into YYYY-MM,
+// which is different in each backend.
+func (s *sqlStorage) getLinkFacets(user *User, filter LinkFilter, size int, month string) (*LinkFacets, error) {
+	where, args := linkFilterConditions(user, filter)
+	var facets LinkFacets
+	var err error
+	facets.Tags, err = s.facetCounts(`SELECT Tag.name, COUNT(*) FROM Link
+		JOIN LinkTag ON LinkTag.link=Link.id
+		JOIN Tag ON Tag.id=LinkTag.tag
+		WHERE `+where+` GROUP BY Tag.name ORDER BY COUNT(*) DESC, Tag.name LIMIT ?`, append(args, size)...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Domains, err = s.facetCounts(`SELECT Link.domain, COUNT(*) FROM Link WHERE `+where+`
+		GROUP BY Link.domain ORDER BY COUNT(*) DESC, Link.domain LIMIT ?`, append(args, size)...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Months, err = s.facetCounts(`SELECT `+month+` AS month, COUNT(*) FROM Link WHERE `+where+`
+		GROUP BY month ORDER BY month`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &facets, nil
+}
+
+func (s *sqlStorage) facetCounts(query string, args ...interface{}) ([]FacetCount, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := []FacetCount{}
+	for rows.Next() {
+		var count FacetCount
+		err = rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+func (s *sqlStorage) InsertLink(link *Link) error {
+	result, err := s.db.Exec(`INSERT INTO Link (url, domain, title, description, timestamp, owner, is_read,
+			custom_title, custom_description, crawl_status, crawled_at, http_status,
+			word_count, language, author, published_at, canonical_url, image_url, site_name,
+			health_status, final_url, health_checked_at, health_failures, archive_hash, archive_type, archived_at,
+			favicon_hash, thumbnail_hash, normalized_url)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		link.URL.String(), link.URL.Hostname(), link.Title, link.Description, link.Timestamp, link.Owner.ID, link.Read,
+		link.CustomTitle, link.CustomDescription, link.CrawlStatus, link.CrawledAt, link.HTTPStatus,
+		link.WordCount, link.Language, link.Author, link.PublishedAt, link.CanonicalURL, link.ImageURL, link.SiteName,
+		link.HealthStatus, link.FinalURL, link.HealthCheckedAt, link.HealthFailures,
+		link.ArchiveHash, link.ArchiveType, link.ArchivedAt, link.FaviconHash, link.ThumbnailHash, link.NormalizedURL)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	link.ID = int(id)
+	return nil
+}
+
+func (s *sqlStorage) UpdateLinkFields(link *Link, resetCrawl bool) (err error) {
+	query := `UPDATE Link SET url=?,domain=?,title=?,description=?,custom_title=?,custom_description=?,is_read=?,
+			timestamp=?,normalized_url=?`
+	args := []interface{}{link.URL.String(), link.URL.Hostname(), link.Title, link.Description,
+		link.CustomTitle, link.CustomDescription, link.Read, link.Timestamp, link.NormalizedURL}
+	if resetCrawl {
+		query += ",crawl_status=?"
+		args = append(args, link.CrawlStatus)
+	}
+	_, err = s.db.Exec(query+" WHERE id=? AND owner=?", append(args, link.ID, link.Owner.ID)...)
+	return
+}
+
+func (s *sqlStorage) UpdateLinkCrawlResult(link *Link) (bool, error) {
+	// The custom flags are checked in the query, as the user may have edited the link while it was being crawled. If
+	// the URL was changed, the result is for the old URL and nothing is stored.
+	result, err := s.db.Exec(`UPDATE Link SET
+			title=CASE WHEN custom_title THEN title ELSE ? END,
+			description=CASE WHEN custom_description THEN description ELSE ? END,
+			crawl_status=?,crawled_at=?,http_status=?,word_count=?,language=?,author=?,published_at=?,
+			canonical_url=?,image_url=?,site_name=?,
+			archive_hash=?,archive_type=?,archived_at=?,favicon_hash=?,thumbnail_hash=?,
+			normalized_url=?
+		WHERE id=? AND owner=? AND url=?`,
+		link.Title, link.Description, link.CrawlStatus, link.CrawledAt, link.HTTPStatus,
+		link.WordCount, link.Language, link.Author, link.PublishedAt, link.CanonicalURL, link.ImageURL, link.SiteName,
+		link.ArchiveHash, link.ArchiveType, link.ArchivedAt, link.FaviconHash, link.ThumbnailHash,
+		link.NormalizedURL,
+		link.ID, link.Owner.ID, link.URL.String())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *sqlStorage) UpdateLinkHealth(link *Link) (err error) {
+	_, err = s.db.Exec(`UPDATE Link SET health_status=?,final_url=?,health_checked_at=?,health_failures=?
+		WHERE id=? AND owner=? AND url=?`,
+		link.HealthStatus, link.FinalURL, link.HealthCheckedAt, link.HealthFailures,
+		link.ID, link.Owner.ID, link.URL.String())
+	return
+}
+
+func (s *sqlStorage) GetLinksToCheck(user *User, checkedBefore int64, limit int) ([]*Link, error) {
+	results, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE Link.owner=? AND Link.health_checked_at<? AND Link.crawl_status<>?
+		GROUP BY Link.id ORDER BY Link.health_checked_at, Link.id LIMIT ?`,
+		user.ID, checkedBefore, CrawlPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanLinks(user, results)
+}
+
+func (s *sqlStorage) GetLinksByNormalizedURL(user *User, normalizedURL string) ([]*Link, error) {
+	results, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE Link.owner=? AND Link.normalized_url=?
+		GROUP BY Link.id ORDER BY Link.id`,
+		user.ID, normalizedURL)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanLinks(user, results)
+}
+
+func (s *sqlStorage) GetDuplicateLinks(user *User) ([]*Link, error) {
+	results, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(Tag.name), '') AS tags FROM Link
+		LEFT JOIN LinkTag ON LinkTag.link = Link.id
+		LEFT JOIN Tag ON LinkTag.tag = Tag.id
+		WHERE Link.owner=? AND Link.normalized_url IN (
+			SELECT normalized_url FROM Link WHERE owner=? AND normalized_url<>''
+			GROUP BY normalized_url HAVING COUNT(*)>1
+		)
+		GROUP BY Link.id ORDER BY Link.normalized_url, Link.id`,
+		user.ID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanLinks(user, results)
+}
+
+func (s *sqlStorage) DeleteLink(link *Link) (err error) {
+	_, err = s.db.Exec("DELETE FROM Link WHERE owner=? AND id=?", link.Owner.ID, link.ID)
+	return
+}
+
+func (s *sqlStorage) SetLinkTags(link *Link, tags []*Tag) error {
+	// To make sure that there are no old tags, we just delete everything and re-add the requested tags.
+	_, err := s.db.Exec("DELETE FROM LinkTag WHERE link=?", link.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	stmt, err := s.db.Prepare("INSERT INTO LinkTag (link, tag) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		stmt.Exec(link.ID, tag.ID)
+	}
+	return stmt.Close()
+}
+
+const tagColumns = "Tag.id, Tag.name, Tag.description, Tag.owner"
+
+// scanTag scans a database row into a Tag object.
+func (s *sqlStorage) scanTag(user *User, row Scannable) (*Tag, error) {
+	var id, ownerID int
+	var name, description string
+	err := row.Scan(&id, &name, &description, &ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return &Tag{
+		DB:    s.db,
+		Owner: user,
+
+		ID:          id,
+		Name:        name,
+		Description: description,
+	}, nil
+}
+
+// scanTags scans multiple database rows into an array of Tags.
+func (s *sqlStorage) scanTags(user *User, rows *sql.Rows) ([]*Tag, error) {
+	defer rows.Close()
+	var tags []*Tag
+	for rows.Next() {
+		tag, err := s.scanTag(user, rows)
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (s *sqlStorage) GetTag(user *User, id int) (*Tag, error) {
+	return s.scanTag(user, s.db.QueryRow("SELECT "+tagColumns+" FROM Tag WHERE id=? AND owner=?", id, user.ID))
+}
+
+func (s *sqlStorage) GetTagByName(user *User, name string) (*Tag, error) {
+	return s.scanTag(user, s.db.QueryRow(
+		"SELECT "+tagColumns+" FROM Tag WHERE name=? AND owner=?",
+		strings.ToLower(name), user.ID))
+}
+
+func (s *sqlStorage) GetTagsByName(user *User, names []string) ([]*Tag, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	args := []interface{}{user.ID}
+	for _, name := range names {
+		args = append(args, strings.ToLower(name))
+	}
+
+	results, err := s.db.Query(
+		fmt.Sprintf("SELECT "+tagColumns+" FROM Tag WHERE owner=? AND name IN (? %s)",
+			strings.Repeat(",?", len(names)-1)),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanTags(user, results)
+}
+
+func (s *sqlStorage) GetTags(user *User) ([]*Tag, error) {
+	results, err := s.db.Query("SELECT "+tagColumns+" FROM Tag WHERE owner=?", user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanTags(user, results)
+}
+
+func (s *sqlStorage) GetTaggedLinks(tag *Tag) ([]*Link, error) {
+	results, err := s.db.Query(`SELECT `+linkColumns+`, IFNULL(GROUP_CONCAT(AllTags.name), '') AS tags FROM Tag
+		JOIN LinkTag ON LinkTag.tag = Tag.id
+		JOIN Link ON LinkTag.link = Link.id
+		LEFT JOIN LinkTag AllLinkTags ON AllLinkTags.link = Link.id
+		LEFT JOIN Tag AllTags ON AllLinkTags.tag = AllTags.id
+		WHERE Tag.owner=? AND Tag.id=?
+		GROUP BY Link.id ORDER BY Link.id DESC`, tag.Owner.ID, tag.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanLinks(tag.Owner, results)
+}
+
+func (s *sqlStorage) SuggestTags(user *User, prefix string, limit int) ([]string, error) {
+	results, err := s.db.Query(`SELECT name FROM Tag WHERE owner=? AND name LIKE ? ESCAPE '!'
+		ORDER BY (SELECT COUNT(*) FROM LinkTag WHERE LinkTag.tag=Tag.id) DESC, name LIMIT ?`,
+		user.ID, escapeLike(strings.ToLower(prefix))+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(results)
+}
+
+func (s *sqlStorage) InsertTag(tag *Tag) error {
+	result, err := s.db.Exec(
+		"INSERT INTO Tag (name, description, owner) VALUES (?, ?, ?)",
+		tag.Name, tag.Description, tag.Owner.ID)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	tag.ID = int(id)
+	return nil
+}
+
+func (s *sqlStorage) UpdateTag(tag *Tag) (err error) {
+	_, err = s.db.Exec(
+		"UPDATE Tag SET name=?,description=? WHERE id=? AND owner=?",
+		tag.Name, tag.Description, tag.ID, tag.Owner.ID)
+	return
+}
+
+func (s *sqlStorage) DeleteTag(tag *Tag) (err error) {
+	_, err = s.db.Exec("DELETE FROM Tag WHERE Tag.owner=? AND Tag.id=?", tag.Owner.ID, tag.ID)
+	return
+}
+
+func (s *sqlStorage) GetSetting(user *User, key string) (value string, err error) {
+	err = s.db.QueryRow("SELECT value FROM Setting WHERE vkey=? AND user=?", key, user.ID).Scan(&value)
+	return
+}
+
+func (s *sqlStorage) GetSettings(user *User) (map[string]string, error) {
+	results, err := s.db.Query("SELECT vkey, value FROM Setting WHERE user=?", user.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	values := make(map[string]string)
+	for results.Next() {
+		var key, value string
+		err = results.Scan(&key, &value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, results.Err()
+}
+
+func (s *sqlStorage) DeleteSetting(user *User, key string) (err error) {
+	_, err = s.db.Exec("DELETE FROM Setting WHERE user=? AND vkey=?", user.ID, key)
+	return
+}
+
+const jobColumns = "id, type, owner, link, payload, status, attempts, last_error, run_at, created_at, updated_at"
+
+func (s *sqlStorage) scanJob(row Scannable) (*Job, error) {
+	job := &Job{DB: s.db}
+	err := row.Scan(&job.ID, &job.Type, &job.Owner, &job.Link, &job.Payload, &job.Status, &job.Attempts,
+		&job.LastError, &job.RunAt, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *sqlStorage) GetJob(id int) (*Job, error) {
+	return s.scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM Job WHERE id=?", id))
+}
+
+func (s *sqlStorage) GetJobs(status JobStatus, limit int) ([]*Job, error) {
+	rows, err := s.db.Query("SELECT "+jobColumns+" FROM Job WHERE status=? ORDER BY id DESC LIMIT ?", status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []*Job
+	for rows.Next() {
+		job, err := s.scanJob(rows)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *sqlStorage) GetLinkJob(owner, link int) (*Job, error) {
+	return s.scanJob(s.db.QueryRow(
+		"SELECT "+jobColumns+" FROM Job WHERE owner=? AND link=? ORDER BY id DESC LIMIT 1", owner, link))
+}
+
+func (s *sqlStorage) GetJobsRunSince(owner int, since int64) ([]*Job, error) {
+	query := "SELECT " + jobColumns + " FROM Job WHERE (status=? OR (status=? AND updated_at>=?))"
+	args := []interface{}{JobRunning, JobDone, since}
+	if owner != 0 {
+		query += " AND owner=?"
+		args = append(args, owner)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []*Job
+	for rows.Next() {
+		job, err := s.scanJob(rows)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *sqlStorage) InsertJob(job *Job) error {
+	result, err := s.db.Exec(`INSERT INTO Job (type, owner, link, payload, status, attempts, last_error, run_at,
+		created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.Type, job.Owner, job.Link, job.Payload, job.Status, job.Attempts, job.LastError, job.RunAt,
+		job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	job.ID = int(id)
+	return nil
+}
+
+func (s *sqlStorage) UpdateJob(job *Job) (err error) {
+	_, err = s.db.Exec("UPDATE Job SET status=?, attempts=?, last_error=?, run_at=?, updated_at=? WHERE id=?",
+		job.Status, job.Attempts, job.LastError, job.RunAt, job.UpdatedAt, job.ID)
+	return
+}
+
+func (s *sqlStorage) ClaimJob(now int64) (*Job, error) {
+	// Multiple workers may select the same job, so the status is checked again when claiming it. If another worker
+	// got the job first, try again with the next one.
+	for {
+		var id int
+		err := s.db.QueryRow("SELECT id FROM Job WHERE status=? AND run_at<=? ORDER BY run_at, id LIMIT 1",
+			JobPending, now).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		result, err := s.db.Exec(
+			"UPDATE Job SET status=?, attempts=attempts+1, updated_at=? WHERE id=? AND status=?",
+			JobRunning, now, id, JobPending)
+		if err != nil {
+			return nil, err
+		} else if affected, _ := result.RowsAffected(); affected == 1 {
+			return s.GetJob(id)
+		}
+	}
+}
+
+func (s *sqlStorage) ResetRunningJobs() (err error) {
+	_, err = s.db.Exec("UPDATE Job SET status=? WHERE status=?", JobPending, JobRunning)
+	return
+}
+
+func (s *sqlStorage) DeleteJobs(status JobStatus, updatedBefore int64) (err error) {
+	_, err = s.db.Exec("DELETE FROM Job WHERE status=? AND updated_at<?", status, updatedBefore)
+	return
+}
+
+const savedSearchColumns = "SavedSearch.id, SavedSearch.name, SavedSearch.search_text, SavedSearch.tags, " +
+	"SavedSearch.domains, SavedSearch.exclusive_tags, SavedSearch.sort_order, SavedSearch.checked_at, " +
+	"SavedSearch.created_at"
+
+// splitList splits a comma-separated list stored in the database. Empty lists are returned as non-nil empty slices.
+func splitList(str string) []string {
+	if len(str) == 0 {
+		return []string{}
+	}
+	return strings.Split(str, ",")
+}
+
+func (s *sqlStorage) scanSavedSearch(user *User, row Scannable) (*SavedSearch, error) {
+	search := user.BlankSavedSearch()
+	var tags, domains string
+	err := row.Scan(&search.ID, &search.Name, &search.Search, &tags, &domains, &search.ExclusiveTags, &search.Sort,
+		&search.CheckedAt, &search.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	search.Tags = splitList(tags)
+	search.Domains = splitList(domains)
+	return search, nil
+}
+
+func (s *sqlStorage) GetSavedSearch(user *User, id int) (*SavedSearch, error) {
+	return s.scanSavedSearch(user, s.db.QueryRow(
+		"SELECT "+savedSearchColumns+" FROM SavedSearch WHERE id=? AND owner=?", id, user.ID))
+}
+
+func (s *sqlStorage) GetSavedSearches(user *User) ([]*SavedSearch, error) {
+	rows, err := s.db.Query("SELECT "+savedSearchColumns+" FROM SavedSearch WHERE owner=? ORDER BY name, id", user.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	searches := []*SavedSearch{}
+	for rows.Next() {
+		search, err := s.scanSavedSearch(user, rows)
+		if err != nil {
+			return searches, err
+		}
+		searches = append(searches, search)
+	}
+	return searches, rows.Err()
+}
+
+func (s *sqlStorage) InsertSavedSearch(search *SavedSearch) error {
+	result, err := s.db.Exec(`INSERT INTO SavedSearch
+		(owner, name, search_text, tags, domains, exclusive_tags, sort_order, checked_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		search.Owner.ID, search.Name, search.Search, strings.Join(search.Tags, ","),
+		strings.Join(search.Domains, ","), search.ExclusiveTags, search.Sort, search.CheckedAt, search.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	search.ID = int(id)
+	return nil
+}
+
+func (s *sqlStorage) UpdateSavedSearch(search *SavedSearch) (err error) {
+	_, err = s.db.Exec(`UPDATE SavedSearch
+		SET name=?, search_text=?, tags=?, domains=?, exclusive_tags=?, sort_order=?, checked_at=?
+		WHERE id=? AND owner=?`,
+		search.Name, search.Search, strings.Join(search.Tags, ","), strings.Join(search.Domains, ","),
+		search.ExclusiveTags, search.Sort, search.CheckedAt, search.ID, search.Owner.ID)
+	return
+}
+
+func (s *sqlStorage) DeleteSavedSearch(search *SavedSearch) (err error) {
+	_, err = s.db.Exec("DELETE FROM SavedSearch WHERE id=? AND owner=?", search.ID, search.Owner.ID)
+	return
+}
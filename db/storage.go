@@ -0,0 +1,112 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package db
+
+// Storage is a database backend.
+//
+// The methods of the entity structs (User, AuthToken, Link, Tag, ...) call the storage instead of running queries
+// themselves, so code outside this package does not need to care about which backend is in use.
+type Storage interface {
+	UserStorage
+	AuthTokenStorage
+	LinkStorage
+	TagStorage
+	SettingStorage
+	JobStorage
+	SavedSearchStorage
+}
+
+// UserStorage contains the storage operations for users.
+type UserStorage interface {
+	GetUser(id int) (*User, error)
+	GetUserByName(name string) (*User, error)
+	GetUsers() ([]*User, error)
+	InsertUser(user *User) error
+	UpdateUser(user *User) error
+}
+
+// AuthTokenStorage contains the storage operations for auth tokens.
+type AuthTokenStorage interface {
+	HasAuthToken(user *User, token string) (bool, error)
+	InsertAuthToken(token *AuthToken) error
+	DeleteAuthToken(token *AuthToken) error
+	DeleteAuthTokens(user *User) error
+}
+
+// LinkStorage contains the storage operations for links.
+type LinkStorage interface {
+	GetLink(user *User, id int) (*Link, error)
+	GetLinks(user *User) ([]*Link, error)
+	QueryLinks(user *User, filter LinkFilter) ([]*Link, error)
+	CountLinks(user *User, filter LinkFilter) (int, error)
+	GetLinkFacets(user *User, filter LinkFilter, size int) (*LinkFacets, error)
+	GetRelatedLinks(link *Link, limit int) ([]*RelatedLink, error)
+	SuggestLinkTitles(user *User, prefix string, limit int) ([]*Link, error)
+	SuggestDomains(user *User, prefix string, limit int) ([]string, error)
+	InsertLink(link *Link) error
+	UpdateLinkFields(link *Link, resetCrawl bool) error
+	UpdateLinkCrawlResult(link *Link) (bool, error)
+	UpdateLinkHealth(link *Link) error
+	GetLinksToCheck(user *User, checkedBefore int64, limit int) ([]*Link, error)
+	GetLinksByNormalizedURL(user *User, normalizedURL string) ([]*Link, error)
+	GetDuplicateLinks(user *User) ([]*Link, error)
+	DeleteLink(link *Link) error
+	SetLinkTags(link *Link, tags []*Tag) error
+}
+
+// TagStorage contains the storage operations for tags.
+type TagStorage interface {
+	GetTag(user *User, id int) (*Tag, error)
+	GetTagByName(user *User, name string) (*Tag, error)
+	GetTagsByName(user *User, names []string) ([]*Tag, error)
+	GetTags(user *User) ([]*Tag, error)
+	GetTaggedLinks(tag *Tag) ([]*Link, error)
+	SuggestTags(user *User, prefix string, limit int) ([]string, error)
+	InsertTag(tag *Tag) error
+	UpdateTag(tag *Tag) error
+	DeleteTag(tag *Tag) error
+}
+
+// SettingStorage contains the storage operations for user settings.
+type SettingStorage interface {
+	GetSetting(user *User, key string) (string, error)
+	GetSettings(user *User) (map[string]string, error)
+	SetSetting(user *User, key, value string) error
+	DeleteSetting(user *User, key string) error
+}
+
+// JobStorage contains the storage operations for background jobs.
+type JobStorage interface {
+	GetJob(id int) (*Job, error)
+	GetJobs(status JobStatus, limit int) ([]*Job, error)
+	GetLinkJob(owner, link int) (*Job, error)
+	GetJobsRunSince(owner int, since int64) ([]*Job, error)
+	InsertJob(job *Job) error
+	UpdateJob(job *Job) error
+	ClaimJob(now int64) (*Job, error)
+	ResetRunningJobs() error
+	DeleteJobs(status JobStatus, updatedBefore int64) error
+}
+
+// SavedSearchStorage contains the storage operations for saved searches.
+type SavedSearchStorage interface {
+	GetSavedSearch(user *User, id int) (*SavedSearch, error)
+	GetSavedSearches(user *User) ([]*SavedSearch, error)
+	InsertSavedSearch(search *SavedSearch) error
+	UpdateSavedSearch(search *SavedSearch) error
+	DeleteSavedSearch(search *SavedSearch) error
+}
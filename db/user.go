@@ -32,39 +32,23 @@ type User struct {
 	PasswordHash []byte
 }
 
-func (db *DB) scanUser(row Scannable) (*User, error) {
-	var scanID int
-	var username, passwordHash string
-	err := row.Scan(&scanID, &username, &passwordHash)
-	if err != nil {
-		return nil, err
-	}
-	return &User{
-		DB:           db,
-		ID:           scanID,
-		Username:     username,
-		PasswordHash: []byte(passwordHash),
-	}, nil
-}
-
 // GetUserByName gets the user with the given username. If the user is not found, nil is returned.
 func (db *DB) GetUserByName(name string) (user *User) {
-	userRow := db.QueryRow("SELECT * FROM User WHERE username=?", name)
-	if userRow != nil {
-		user, _ = db.scanUser(userRow)
-	}
+	user, _ = db.Storage.GetUserByName(name)
 	return
 }
 
 // GetUser gets the user with the given ID. If the user is not found, nil is returned.
 func (db *DB) GetUser(id int) (user *User) {
-	userRow := db.QueryRow("SELECT * FROM User WHERE id=?", id)
-	if userRow != nil {
-		user, _ = db.scanUser(userRow)
-	}
+	user, _ = db.Storage.GetUser(id)
 	return
 }
 
+// GetUsers gets all the registered users.
+func (db *DB) GetUsers() ([]*User, error) {
+	return db.Storage.GetUsers()
+}
+
 func (user *User) IDString() string {
 	return strconv.Itoa(user.ID)
 }
@@ -94,26 +78,12 @@ func (user *User) SetPassword(password string) error {
 }
 
 // Update updates the username and password of this user in the database.
-func (user *User) Update() (err error) {
-	_, err = user.DB.Exec(
-		"UPDATE User SET username=?,password=? WHERE id=?",
-		user.Username, string(user.PasswordHash), user.ID)
-	return
+func (user *User) Update() error {
+	return user.DB.Storage.UpdateUser(user)
 }
 
 // Insert stores the username and password of this user into the database and
 // fills in the ID field of the struct with the ID of the inserted row.
 func (user *User) Insert() error {
-	result, err := user.DB.Exec(
-		"INSERT INTO User (username, password) VALUES (?, ?)",
-		user.Username, string(user.PasswordHash))
-	if err != nil {
-		return err
-	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
-	user.ID = int(id)
-	return nil
+	return user.DB.Storage.InsertUser(user)
 }
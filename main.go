@@ -23,17 +23,23 @@ import (
 
 	"fmt"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	"maunium.net/go/lindeb/api"
+	"maunium.net/go/lindeb/crawler"
 	flag "maunium.net/go/mauflag"
 )
 
 var configPath = flag.MakeFull("c", "config", "Path to the config file.", "config.yaml").String()
+var reindexUser = flag.MakeFull("u", "user", "Only reindex the links of the user with the given ID.", "0").
+	UsageCategory("Reindex").Int()
+var reindexRecrawl = flag.MakeFull("r", "recrawl", "Fetch the pages again when reindexing.", "false").
+	UsageCategory("Reindex").Bool()
 var wantHelp, _ = flag.MakeHelpFlag()
 
 func main() {
-	flag.SetHelpTitles("lindeb - mau\\Lu Link Database", "lindeb [-c /path/to/config] [-h]")
+	flag.SetHelpTitles(
+		"lindeb - mau\\Lu Link Database",
+		"lindeb [-c /path/to/config] [-h] [migrate <status|up|down> [version] | reindex [--user N] [--recrawl]]")
 	err := flag.Parse()
 	if err != nil {
 		fmt.Println(err)
@@ -49,29 +55,59 @@ func main() {
 		fmt.Println("Failed to load config:", err)
 		os.Exit(10)
 	}
+	fetcher, err := crawler.NewFetcher(config.Crawler)
+	if err != nil {
+		fmt.Println("Invalid crawler config:", err)
+		os.Exit(10)
+	}
 
 	db, err := config.Database.Connect()
 	if err != nil {
 		fmt.Println("Database connection failed:", err)
 		os.Exit(11)
 	}
-	db.CreateTables()
 
-	search, err := config.Elastic.Connect()
+	if flag.NArg() > 0 {
+		exitCode := runCommand(config, db, flag.Args())
+		db.Close()
+		os.Exit(exitCode)
+	}
+
+	err = db.Upgrade()
+	if err != nil {
+		fmt.Println("Failed to upgrade database schema:", err)
+		os.Exit(13)
+	}
+
+	index, err := config.Search.Connect()
 	if err != nil {
-		fmt.Println("Elasticsearch connection failed:", err)
+		fmt.Println("Failed to open search index:", err)
 		os.Exit(12)
 	}
 
+	archiveStore, err := config.Archive.Open()
+	if err != nil {
+		fmt.Println("Failed to open page archive:", err)
+		os.Exit(14)
+	}
+	thumbnailCache, err := config.Thumbnails.Open()
+	if err != nil {
+		fmt.Println("Failed to open thumbnail cache:", err)
+		os.Exit(15)
+	}
+
 	r := mux.NewRouter()
 
-	api := api.Create(db, search)
+	api := api.Create(db, index, config.Queue, fetcher)
+	api.Admins = config.API.Admins
+	api.HealthCheck = config.Health
+	api.Archive = archiveStore
+	api.Thumbnails = thumbnailCache
 	api.AddHandler(r.PathPrefix(config.API.Prefix).Subrouter())
 	config.Frontend.AddHandler(r)
 
-	go api.StartElasticQueue()
-	go api.StartElasticQueue()
-	go api.StartElasticQueue()
+	api.StartJobQueue()
+	api.StartHealthChecker()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -83,8 +119,7 @@ func main() {
 		}
 		db.Close()
 		api.Stop()
-		// TODO check if this does what's expected
-		search.Stop()
+		index.Close()
 		os.Exit(1)
 	}()
 
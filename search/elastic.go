@@ -0,0 +1,897 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+// ElasticIndex is the name of the alias that points to the index currently in use. Before reindexing was supported,
+// this was the name of the index itself.
+const ElasticIndex = "lindeb"
+const ElasticType = "link"
+
+// elasticBatchSize is the maximum number of documents sent in one bulk or multi-get request.
+const elasticBatchSize = 500
+
+const elasticMapping = `
+{
+	"settings": {
+		"number_of_shards": 1,
+		"number_of_replicas": 0,
+		"analysis": {
+			"analyzer": {
+				"tag_analyzer": {
+					"tokenizer": "keyword"
+				},
+				"prefix_analyzer": {
+					"tokenizer": "standard",
+					"filter": ["lowercase", "prefix_filter"]
+				}
+			},
+			"filter": {
+				"prefix_filter": {
+					"type": "edge_ngram",
+					"min_gram": 1,
+					"max_gram": 20
+				}
+			},
+			"normalizer": {
+				"sort_normalizer": {
+					"type": "custom",
+					"filter": ["lowercase"]
+				}
+			}
+		}
+	},
+	"mappings": {
+		"link": {
+			"_routing": {
+				"required": true
+			},
+			"properties": {
+				"id": {
+					"type": "integer",
+					"store": true
+				},
+				"owner": {
+					"type": "integer",
+					"store": true
+				},
+				"url": {
+					"type": "text"
+				},
+				"domain": {
+					"type": "text",
+					"fields": {
+						"keyword": {
+							"type": "keyword"
+						}
+					}
+				},
+				"tags": {
+					"type": "text",
+					"analyzer": "tag_analyzer",
+					"fields": {
+						"keyword": {
+							"type": "keyword"
+						}
+					}
+				},
+				"title": {
+					"type": "text",
+					"fields": {
+						"sort": {
+							"type": "keyword",
+							"normalizer": "sort_normalizer"
+						},
+						"prefix": {
+							"type": "text",
+							"analyzer": "prefix_analyzer",
+							"search_analyzer": "standard"
+						}
+					}
+				},
+				"description": {
+					"type": "text"
+				},
+				"timestamp": {
+					"type": "long"
+				},
+				"read": {
+					"type": "boolean"
+				},
+				"language": {
+					"type": "keyword"
+				},
+				"content": {
+					"type": "text"
+				},
+				"content_arabic": {
+					"type": "text",
+					"analyzer": "arabic"
+				},
+				"content_armenian": {
+					"type": "text",
+					"analyzer": "armenian"
+				},
+				"content_basque": {
+					"type": "text",
+					"analyzer": "basque"
+				},
+				"content_bulgarian": {
+					"type": "text",
+					"analyzer": "bulgarian"
+				},
+				"content_catalan": {
+					"type": "text",
+					"analyzer": "catalan"
+				},
+				"content_czech": {
+					"type": "text",
+					"analyzer": "czech"
+				},
+				"content_danish": {
+					"type": "text",
+					"analyzer": "danish"
+				},
+				"content_dutch": {
+					"type": "text",
+					"analyzer": "dutch"
+				},
+				"content_english": {
+					"type": "text",
+					"analyzer": "english"
+				},
+				"content_finnish": {
+					"type": "text",
+					"analyzer": "finnish"
+				},
+				"content_french": {
+					"type": "text",
+					"analyzer": "french"
+				},
+				"content_galician": {
+					"type": "text",
+					"analyzer": "galician"
+				},
+				"content_german": {
+					"type": "text",
+					"analyzer": "german"
+				},
+				"content_greek": {
+					"type": "text",
+					"analyzer": "greek"
+				},
+				"content_hindi": {
+					"type": "text",
+					"analyzer": "hindi"
+				},
+				"content_hungarian": {
+					"type": "text",
+					"analyzer": "hungarian"
+				},
+				"content_indonesian": {
+					"type": "text",
+					"analyzer": "indonesian"
+				},
+				"content_irish": {
+					"type": "text",
+					"analyzer": "irish"
+				},
+				"content_italian": {
+					"type": "text",
+					"analyzer": "italian"
+				},
+				"content_latvian": {
+					"type": "text",
+					"analyzer": "latvian"
+				},
+				"content_lithuanian": {
+					"type": "text",
+					"analyzer": "lithuanian"
+				},
+				"content_norwegian": {
+					"type": "text",
+					"analyzer": "norwegian"
+				},
+				"content_persian": {
+					"type": "text",
+					"analyzer": "persian"
+				},
+				"content_portuguese": {
+					"type": "text",
+					"analyzer": "portuguese"
+				},
+				"content_romanian": {
+					"type": "text",
+					"analyzer": "romanian"
+				},
+				"content_russian": {
+					"type": "text",
+					"analyzer": "russian"
+				},
+				"content_spanish": {
+					"type": "text",
+					"analyzer": "spanish"
+				},
+				"content_swedish": {
+					"type": "text",
+					"analyzer": "swedish"
+				},
+				"content_thai": {
+					"type": "text",
+					"analyzer": "thai"
+				},
+				"content_turkish": {
+					"type": "text",
+					"analyzer": "turkish"
+				}
+			}
+		}
+	}
+}`
+
+// elasticContentFields maps language codes to the fields in the mapping that analyze the content with the
+// Elasticsearch analyzer of the language. The content is indexed in the field of the document's language in addition
+// to the language-neutral content field, which is used for phrases and highlighting.
+var elasticContentFields = map[string]string{
+	"ar": "content_arabic",
+	"bg": "content_bulgarian",
+	"ca": "content_catalan",
+	"cs": "content_czech",
+	"da": "content_danish",
+	"de": "content_german",
+	"el": "content_greek",
+	"en": "content_english",
+	"es": "content_spanish",
+	"eu": "content_basque",
+	"fa": "content_persian",
+	"fi": "content_finnish",
+	"fr": "content_french",
+	"ga": "content_irish",
+	"gl": "content_galician",
+	"hi": "content_hindi",
+	"hu": "content_hungarian",
+	"hy": "content_armenian",
+	"id": "content_indonesian",
+	"it": "content_italian",
+	"lt": "content_lithuanian",
+	"lv": "content_latvian",
+	"nl": "content_dutch",
+	"no": "content_norwegian",
+	"nb": "content_norwegian",
+	"nn": "content_norwegian",
+	"pt": "content_portuguese",
+	"ro": "content_romanian",
+	"ru": "content_russian",
+	"sv": "content_swedish",
+	"th": "content_thai",
+	"tr": "content_turkish",
+}
+
+// elasticSource returns the source of the document to store in Elasticsearch.
+func elasticSource(doc Document) (interface{}, error) {
+	field, ok := elasticContentFields[doc.Language]
+	if !ok || len(doc.Content) == 0 {
+		return doc, nil
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var source map[string]interface{}
+	err = json.Unmarshal(data, &source)
+	if err != nil {
+		return nil, err
+	}
+	source[field] = doc.Content
+	return source, nil
+}
+
+// Elastic is a search index stored in an Elasticsearch server.
+type Elastic struct {
+	Client *elastic.Client
+}
+
+// NewElastic connects to the Elasticsearch server at the given URL and creates the index if it doesn't exist.
+func NewElastic(url string) (*Elastic, error) {
+	client, err := elastic.NewClient(
+		elastic.SetURL(url),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := client.ElasticsearchVersion(url)
+	if err != nil {
+		return nil, err
+	}
+
+	es := &Elastic{client}
+	ctx := context.Background()
+	exists, err := client.IndexExists(ElasticIndex).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		index, err := es.createIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		_, err = client.Alias().Add(index, ElasticIndex).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("Connected to Elasticsearch v%s.\n", version)
+	return es, nil
+}
+
+// createIndex creates a new timestamped index with the current mapping and returns its name.
+func (es *Elastic) createIndex(ctx context.Context) (string, error) {
+	name := fmt.Sprintf("%s-%d", ElasticIndex, time.Now().UnixNano())
+	_, err := es.Client.CreateIndex(name).BodyString(elasticMapping).Do(ctx)
+	return name, err
+}
+
+// currentIndices gets the names of the indices the alias points to. If the index was created before aliases were
+// used, the index itself is returned and legacy is set to true.
+func (es *Elastic) currentIndices(ctx context.Context) (indices []string, legacy bool, err error) {
+	exists, err := es.Client.IndexExists(ElasticIndex).Do(ctx)
+	if err != nil || !exists {
+		return
+	}
+	aliases, err := es.Client.Aliases().Index(ElasticIndex).Do(ctx)
+	if err != nil {
+		return
+	}
+	indices = aliases.IndicesByAlias(ElasticIndex)
+	if len(indices) == 0 {
+		indices = []string{ElasticIndex}
+		legacy = true
+	}
+	return
+}
+
+func (es *Elastic) Index(doc Document) error {
+	source, err := elasticSource(doc)
+	if err != nil {
+		return err
+	}
+	_, err = es.Client.Index().
+		Index(ElasticIndex).
+		Type(ElasticType).
+		Routing(strconv.Itoa(doc.Owner)).
+		Id(strconv.Itoa(doc.ID)).
+		BodyJson(source).
+		Do(context.Background())
+	return err
+}
+
+func (es *Elastic) Update(doc Document) error {
+	source, err := elasticSource(doc)
+	if err != nil {
+		return err
+	}
+	_, err = es.Client.Update().
+		Index(ElasticIndex).
+		Type(ElasticType).
+		Routing(strconv.Itoa(doc.Owner)).
+		Id(strconv.Itoa(doc.ID)).
+		Doc(source).
+		// Links that haven't been indexed yet, for example because crawling them failed, are inserted.
+		DocAsUpsert(true).
+		Do(context.Background())
+	return err
+}
+
+func (es *Elastic) Delete(owner, id int) (err error) {
+	_, err = es.Client.Delete().
+		Index(ElasticIndex).
+		Type(ElasticType).
+		Routing(strconv.Itoa(owner)).
+		Id(strconv.Itoa(id)).
+		Do(context.Background())
+	return
+}
+
+func stringToInterfaceSlice(slice []string) []interface{} {
+	new := make([]interface{}, len(slice))
+	for i, v := range slice {
+		new[i] = v
+	}
+	return new
+}
+
+func buildQuery(q Query) elastic.Query {
+	query := elastic.NewBoolQuery()
+	query.Filter(elastic.NewTermQuery("owner", q.Owner))
+	if len(strings.TrimSpace(q.Text)) > 0 {
+		query.MinimumNumberShouldMatch(1)
+		query.Should(elastic.NewMultiMatchQuery(q.Text, "content", "content_*").Boost(0.3))
+		query.Should(elastic.NewFuzzyQuery("url", q.Text).Boost(0.3))
+		query.Should(elastic.NewMultiMatchQuery(q.Text, "title", "description").Fuzziness("auto").Boost(1.5))
+	}
+	for _, phrase := range q.Phrases {
+		query.Must(elastic.NewMultiMatchQuery(phrase, "title", "description", "content", "url").Type("phrase"))
+	}
+	for _, text := range q.ExcludedText {
+		query.MustNot(elastic.NewMultiMatchQuery(text, "title", "description", "content", "url").Type("phrase"))
+	}
+	for _, title := range q.Title {
+		query.Must(elastic.NewMatchPhraseQuery("title", title))
+	}
+	if len(q.Tags) > 0 {
+		if q.ExclusiveTags {
+			for _, tag := range q.Tags {
+				query.Must(elastic.NewTermQuery("tags", tag))
+			}
+		} else {
+			query.Must(elastic.NewTermsQuery("tags", stringToInterfaceSlice(q.Tags)...))
+		}
+	}
+	for _, tag := range q.RequiredTags {
+		query.Must(elastic.NewTermQuery("tags", tag))
+	}
+	if len(q.ExcludedTags) > 0 {
+		query.MustNot(elastic.NewTermsQuery("tags", stringToInterfaceSlice(q.ExcludedTags)...))
+	}
+	if len(q.Domains) > 0 {
+		query.Must(elastic.NewTermsQuery("domain", stringToInterfaceSlice(q.Domains)...))
+	}
+	if len(q.ExcludedDomains) > 0 {
+		query.MustNot(elastic.NewTermsQuery("domain", stringToInterfaceSlice(q.ExcludedDomains)...))
+	}
+	if q.Read != nil {
+		// Documents indexed before the read state was added don't have the field, so unread is matched negatively.
+		if *q.Read {
+			query.Filter(elastic.NewTermQuery("read", true))
+		} else {
+			query.MustNot(elastic.NewTermQuery("read", true))
+		}
+	}
+	if q.Since != 0 || q.Until != 0 {
+		timeRange := elastic.NewRangeQuery("timestamp")
+		if q.Since != 0 {
+			timeRange.Gte(q.Since)
+		}
+		if q.Until != 0 {
+			timeRange.Lte(q.Until)
+		}
+		query.Filter(timeRange)
+	}
+	return query
+}
+
+// elasticMaxResults is the maximum number of results Elasticsearch returns by default (index.max_result_window).
+const elasticMaxResults = 10000
+
+// elasticSorters returns the sort order for the given sort option. The sort fields were added to the mapping later,
+// so missing mappings are ignored to keep indices created before that working until they're rebuilt.
+func elasticSorters(sort Sort) []elastic.Sorter {
+	newest := elastic.NewFieldSort("timestamp").Desc().UnmappedType("long")
+	switch sort {
+	case SortNewest:
+		return []elastic.Sorter{newest}
+	case SortOldest:
+		return []elastic.Sorter{elastic.NewFieldSort("timestamp").Asc().UnmappedType("long")}
+	case SortTitle:
+		return []elastic.Sorter{elastic.NewFieldSort("title.sort").Asc().UnmappedType("keyword"), newest}
+	case SortDomain:
+		return []elastic.Sorter{elastic.NewFieldSort("domain.keyword").Asc().UnmappedType("keyword"), newest}
+	default:
+		return []elastic.Sorter{elastic.NewScoreSort(), newest}
+	}
+}
+
+func (es *Elastic) Query(q Query) (results Results, err error) {
+	from, size := q.From, q.Size
+	if size <= 0 || from+size > elasticMaxResults {
+		size = elasticMaxResults - from
+	}
+	if size < 0 {
+		// The page is past the result window, so only get the total number of hits.
+		from, size = 0, 0
+	}
+
+	search := es.Client.Search().
+		Index(ElasticIndex).
+		Type(ElasticType).
+		Routing(strconv.Itoa(q.Owner)).
+		Query(buildQuery(q)).
+		SortBy(elasticSorters(q.Sort)...).
+		FetchSourceContext(elasticSourceContext).
+		From(from).
+		Size(size)
+	if q.Highlight {
+		search = search.Highlight(elasticHighlight)
+	}
+	if q.FacetSize > 0 {
+		// The keyword fields were added to the mapping later, so indices created before that return empty tag and
+		// domain facets until they're rebuilt.
+		search = search.
+			Aggregation("tags", elastic.NewTermsAggregation().Field("tags.keyword").Size(q.FacetSize)).
+			Aggregation("domains", elastic.NewTermsAggregation().Field("domain.keyword").Size(q.FacetSize)).
+			// Timestamps are stored in seconds, but date histograms expect milliseconds.
+			Aggregation("months", elastic.NewDateHistogramAggregation().
+				Script(elastic.NewScript("doc['timestamp'].value * 1000")).
+				Interval("month").
+				Format("yyyy-MM").
+				MinDocCount(1))
+	}
+	resp, err := search.Do(context.Background())
+	if err != nil {
+		return
+	}
+	results.TotalHits = int(resp.TotalHits())
+	if q.FacetSize > 0 {
+		results.Facets = elasticFacets(resp.Aggregations)
+	}
+	if resp.Hits == nil {
+		return
+	}
+	for _, hit := range resp.Hits.Hits {
+		var doc Document
+		doc, err = decodeHit(hit)
+		if err != nil {
+			return
+		}
+		for field, fragments := range hit.Highlight {
+			for _, fragment := range fragments {
+				doc.addHighlight(field, fragment)
+			}
+		}
+		results.Documents = append(results.Documents, doc)
+	}
+	return
+}
+
+// elasticSourceContext excludes the page content from search results, as it can be large and isn't needed. Highlighting
+// still works, as it reads the source on the Elasticsearch side.
+var elasticSourceContext = elastic.NewFetchSourceContext(true).Exclude("content", "content_*", "html")
+
+// decodeHit decodes the document in a search hit.
+func decodeHit(hit *elastic.SearchHit) (doc Document, err error) {
+	if hit.Source == nil {
+		return doc, fmt.Errorf("search hit %s has no source", hit.Id)
+	}
+	err = json.Unmarshal(*hit.Source, &doc)
+	if hit.Score != nil {
+		doc.Score = *hit.Score
+	}
+	return
+}
+
+// Related finds documents similar to the given document with a more_like_this query. The thresholds are lower than
+// the Elasticsearch defaults, as a single user's links are a small collection.
+func (es *Elastic) Related(owner, id, size int) (docs []Document, err error) {
+	routing := strconv.Itoa(owner)
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("owner", owner)).
+		Must(elastic.NewMoreLikeThisQuery().
+			Field("title", "description", "content", "tags").
+			LikeItems(elastic.NewMoreLikeThisQueryItem().
+				Index(ElasticIndex).
+				Type(ElasticType).
+				Id(strconv.Itoa(id)).
+				Routing(routing)).
+			MinTermFreq(1).
+			MinDocFreq(1).
+			MaxQueryTerms(50))
+	resp, err := es.Client.Search().
+		Index(ElasticIndex).
+		Type(ElasticType).
+		Routing(routing).
+		Query(query).
+		FetchSourceContext(elasticSourceContext).
+		Size(size).
+		Do(context.Background())
+	if err != nil || resp.Hits == nil {
+		return
+	}
+	for _, hit := range resp.Hits.Hits {
+		var doc Document
+		doc, err = decodeHit(hit)
+		if err != nil {
+			return
+		}
+		docs = append(docs, doc)
+	}
+	return
+}
+
+// SuggestTitles finds documents whose title has words starting with the words in the given text. Indices created before
+// the prefix field was added to the mapping return nothing until they're rebuilt.
+func (es *Elastic) SuggestTitles(owner int, text string, size int) (docs []Document, err error) {
+	query := elastic.NewBoolQuery().
+		Filter(elastic.NewTermQuery("owner", owner)).
+		Must(elastic.NewMatchQuery("title.prefix", text).Operator("and"))
+	resp, err := es.Client.Search().
+		Index(ElasticIndex).
+		Type(ElasticType).
+		Routing(strconv.Itoa(owner)).
+		Query(query).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("id", "owner", "title", "url", "domain")).
+		Size(size).
+		Do(context.Background())
+	if err != nil || resp.Hits == nil {
+		return
+	}
+	for _, hit := range resp.Hits.Hits {
+		var doc Document
+		doc, err = decodeHit(hit)
+		if err != nil {
+			return
+		}
+		docs = append(docs, doc)
+	}
+	return
+}
+
+// elasticFacets converts the facet aggregations of a search response.
+func elasticFacets(aggs elastic.Aggregations) *Facets {
+	facets := &Facets{
+		Tags:    elasticTermCounts(aggs, "tags"),
+		Domains: elasticTermCounts(aggs, "domains"),
+		Months:  []FacetCount{},
+	}
+	if months, ok := aggs.DateHistogram("months"); ok {
+		for _, bucket := range months.Buckets {
+			if bucket.KeyAsString != nil {
+				facets.Months = append(facets.Months, FacetCount{*bucket.KeyAsString, int(bucket.DocCount)})
+			}
+		}
+	}
+	return facets
+}
+
+func elasticTermCounts(aggs elastic.Aggregations, name string) []FacetCount {
+	counts := []FacetCount{}
+	if terms, ok := aggs.Terms(name); ok {
+		for _, bucket := range terms.Buckets {
+			counts = append(counts, FacetCount{fmt.Sprint(bucket.Key), int(bucket.DocCount)})
+		}
+	}
+	return counts
+}
+
+// elasticHighlight requests highlights from the fields that are searched.
+var elasticHighlight = elastic.NewHighlight().
+	PreTags(highlightStart).
+	PostTags(highlightEnd).
+	Fields(
+		elastic.NewHighlighterField("title").NumOfFragments(0),
+		elastic.NewHighlighterField("description").FragmentSize(150).NumOfFragments(2),
+		elastic.NewHighlighterField("content").FragmentSize(150).NumOfFragments(3),
+	)
+
+// Reindex fills a new index and then atomically swaps the alias to point to it. Changes made to the old index while
+// the reindex is running are not copied to the new index.
+//
+// If an owner is given, the documents of that owner are replaced in the current index instead. Documents of the owner
+// that were added while the reindex was running are deleted if they're not in the channel.
+func (es *Elastic) Reindex(owner int, docs <-chan Document) error {
+	defer drain(docs)
+	if owner != 0 {
+		return es.reindexOwner(owner, docs)
+	}
+
+	ctx := context.Background()
+	oldIndices, legacy, err := es.currentIndices(ctx)
+	if err != nil {
+		return err
+	}
+	newIndex, err := es.createIndex(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = es.bulkIndex(ctx, newIndex, docs, len(oldIndices) > 0)
+	if err != nil {
+		es.Client.DeleteIndex(newIndex).Do(ctx)
+		return err
+	}
+	_, err = es.Client.Refresh(newIndex).Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	if legacy {
+		return es.replaceLegacyIndex(ctx, newIndex)
+	}
+
+	swap := es.Client.Alias().Add(newIndex, ElasticIndex)
+	for _, index := range oldIndices {
+		swap.Remove(index, ElasticIndex)
+	}
+	_, err = swap.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if len(oldIndices) > 0 {
+		_, err = es.Client.DeleteIndex(oldIndices...).Do(ctx)
+	}
+	return err
+}
+
+// aliasRemoveIndexAction is an alias action that deletes an index. It's used to replace an index with an alias of the
+// same name in a single step.
+type aliasRemoveIndexAction string
+
+func (index aliasRemoveIndexAction) Source() (interface{}, error) {
+	return map[string]interface{}{
+		"remove_index": map[string]interface{}{"index": string(index)},
+	}, nil
+}
+
+// replaceLegacyIndex replaces the index created before aliases were used with an alias pointing to the new index. An
+// alias can't have the same name as an index, so the old index is deleted in the same request that adds the alias.
+// Elasticsearch versions without the remove_index alias action need the index to be deleted separately first, which
+// leaves searches failing if adding the alias fails.
+func (es *Elastic) replaceLegacyIndex(ctx context.Context, newIndex string) error {
+	_, err := es.Client.Alias().
+		Action(aliasRemoveIndexAction(ElasticIndex)).
+		Add(newIndex, ElasticIndex).
+		Do(ctx)
+	if err == nil {
+		return nil
+	} else if elasticErr, ok := err.(*elastic.Error); !ok || elasticErr.Status != http.StatusBadRequest {
+		return err
+	}
+
+	_, err = es.Client.DeleteIndex(ElasticIndex).Do(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = es.Client.Alias().Add(newIndex, ElasticIndex).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("deleted old index, but failed to add alias %s to new index %s: %v", ElasticIndex, newIndex, err)
+	}
+	return nil
+}
+
+// reindexOwner replaces the documents of a single owner in the current index.
+func (es *Elastic) reindexOwner(owner int, docs <-chan Document) error {
+	ctx := context.Background()
+	ids, err := es.bulkIndex(ctx, ElasticIndex, docs, true)
+	if err != nil {
+		return err
+	}
+	_, err = es.Client.DeleteByQuery(ElasticIndex).
+		Type(ElasticType).
+		Routing(strconv.Itoa(owner)).
+		Query(elastic.NewBoolQuery().
+			Filter(elastic.NewTermQuery("owner", owner)).
+			MustNot(elastic.NewIdsQuery(ElasticType).Ids(ids...))).
+		Do(ctx)
+	return err
+}
+
+// bulkIndex indexes the documents from the channel into the given index in batches and returns the IDs of the indexed
+// documents. If keepContent is true, the content of documents that don't have it is copied from the current index.
+func (es *Elastic) bulkIndex(ctx context.Context, index string, docs <-chan Document, keepContent bool) ([]string, error) {
+	var ids []string
+	batch := make([]Document, 0, elasticBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if keepContent {
+			err := es.fillContent(ctx, batch)
+			if err != nil {
+				return err
+			}
+		}
+		bulk := es.Client.Bulk().Index(index).Type(ElasticType)
+		for _, doc := range batch {
+			source, err := elasticSource(doc)
+			if err != nil {
+				return err
+			}
+			bulk.Add(elastic.NewBulkIndexRequest().
+				Routing(strconv.Itoa(doc.Owner)).
+				Id(strconv.Itoa(doc.ID)).
+				Doc(source))
+		}
+		resp, err := bulk.Do(ctx)
+		if err != nil {
+			return err
+		} else if resp.Errors {
+			failed := resp.Failed()
+			return fmt.Errorf("failed to index %d documents: %s", len(failed), failed[0].Error.Reason)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for doc := range docs {
+		batch = append(batch, doc)
+		ids = append(ids, strconv.Itoa(doc.ID))
+		if len(batch) >= elasticBatchSize {
+			err := flush()
+			if err != nil {
+				return ids, err
+			}
+		}
+	}
+	return ids, flush()
+}
+
+// fillContent copies the content and language of the documents that don't have content from the current index.
+// Indices created before the content field was added store the whole HTML of the page instead, so the text is
+// extracted from it.
+func (es *Elastic) fillContent(ctx context.Context, batch []Document) error {
+	mget := es.Client.MultiGet()
+	var missing []int
+	for index, doc := range batch {
+		if len(doc.Content) > 0 {
+			continue
+		}
+		mget.Add(elastic.NewMultiGetItem().
+			Index(ElasticIndex).
+			Type(ElasticType).
+			Routing(strconv.Itoa(doc.Owner)).
+			Id(strconv.Itoa(doc.ID)).
+			FetchSource(elastic.NewFetchSourceContext(true).Include("content", "language", "html")))
+		missing = append(missing, index)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	resp, err := mget.Do(ctx)
+	if err != nil {
+		return err
+	}
+	for index, item := range resp.Docs {
+		if !item.Found || item.Source == nil || index >= len(missing) {
+			continue
+		}
+		var source struct {
+			Content  string `json:"content"`
+			Language string `json:"language"`
+			HTML     string `json:"html"`
+		}
+		err = json.Unmarshal(*item.Source, &source)
+		if err != nil {
+			return err
+		}
+		doc := &batch[missing[index]]
+		doc.Content = source.Content
+		if len(doc.Content) == 0 {
+			doc.Content = htmlToText(source.HTML)
+		}
+		if len(doc.Language) == 0 {
+			doc.Language = source.Language
+		}
+	}
+	return nil
+}
+
+func (es *Elastic) Close() error {
+	es.Client.Stop()
+	return nil
+}
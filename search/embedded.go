@@ -0,0 +1,526 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const embeddedDriver = "sqlite3_lindeb_search"
+
+// Column weights for ranking embedded search results. These are the same as the boosts used with Elasticsearch.
+var embeddedWeights = []float64{
+	1.5, // title
+	1.5, // description
+	0.3, // url
+	0.1, // content
+}
+
+func init() {
+	sql.Register(embeddedDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("rank", rank, true)
+		},
+	})
+}
+
+// rank calculates a relevance score from the output of matchinfo(DocumentText, 'pcx').
+//
+// matchinfo returns native-endian 32-bit integers, which means little-endian on all the platforms lindeb runs on.
+func rank(matchInfo []byte) float64 {
+	info := make([]uint32, len(matchInfo)/4)
+	for i := range info {
+		info[i] = binary.LittleEndian.Uint32(matchInfo[i*4:])
+	}
+	if len(info) < 2 {
+		return 0
+	}
+	phrases, columns := int(info[0]), int(info[1])
+	var score float64
+	for phrase := 0; phrase < phrases; phrase++ {
+		for column := 0; column < columns && column < len(embeddedWeights); column++ {
+			offset := 2 + (phrase*columns+column)*3
+			if offset+1 >= len(info) {
+				return score
+			}
+			hitsInRow, hitsInAllRows := info[offset], info[offset+1]
+			if hitsInRow > 0 {
+				score += embeddedWeights[column] * float64(hitsInRow) / float64(hitsInAllRows)
+			}
+		}
+	}
+	return score
+}
+
+// Embedded is a search index stored in an SQLite full-text search database on disk.
+type Embedded struct {
+	DB *sql.DB
+}
+
+// NewEmbedded opens the embedded search index at the given path and creates the tables if they don't exist.
+func NewEmbedded(path string) (*Embedded, error) {
+	db, err := sql.Open(embeddedDriver, path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	for _, query := range []string{`CREATE TABLE IF NOT EXISTS Document (
+		id          INTEGER PRIMARY KEY,
+		owner       INTEGER NOT NULL,
+		url         TEXT    NOT NULL,
+		domain      TEXT    NOT NULL,
+		title       TEXT    NOT NULL,
+		description TEXT    NOT NULL,
+		timestamp   BIGINT  NOT NULL,
+		is_read     BOOLEAN NOT NULL DEFAULT 0
+	)`, `CREATE INDEX IF NOT EXISTS document_owner ON Document (owner)`, `CREATE TABLE IF NOT EXISTS DocumentTag (
+		document INTEGER NOT NULL REFERENCES Document(id) ON DELETE CASCADE,
+		tag      TEXT    NOT NULL,
+
+		PRIMARY KEY (document, tag)
+	)`, `CREATE VIRTUAL TABLE IF NOT EXISTS DocumentText USING fts4(
+		title, description, url, content,
+		tokenize=unicode61 "remove_diacritics=1"
+	)`} {
+		_, err = db.Exec(query)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	err = addColumnIfMissing(db, "Document", "is_read", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	fmt.Println("Opened embedded search index at", path)
+	return &Embedded{db}, nil
+}
+
+// addColumnIfMissing adds a column to a table created by an older version of lindeb.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		} else if name == column {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func setDocumentTags(tx execer, doc Document) error {
+	_, err := tx.Exec("DELETE FROM DocumentTag WHERE document=?", doc.ID)
+	if err != nil {
+		return err
+	}
+	for _, tag := range doc.Tags {
+		_, err = tx.Exec("INSERT OR IGNORE INTO DocumentTag (document, tag) VALUES (?, ?)", doc.ID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertDocument(tx execer, doc Document, content string) error {
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO Document (id, owner, url, domain, title, description, timestamp, is_read)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		doc.ID, doc.Owner, doc.URL, doc.Domain, doc.Title, doc.Description, doc.Timestamp, doc.Read)
+	if err != nil {
+		return err
+	}
+	err = setDocumentTags(tx, doc)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM DocumentText WHERE docid=?", doc.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO DocumentText (docid, title, description, url, content) VALUES (?, ?, ?, ?, ?)",
+		doc.ID, doc.Title, doc.Description, doc.URL, content)
+	return err
+}
+
+// inTransaction runs the given function in a database transaction and commits if the function doesn't fail.
+func (emb *Embedded) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := emb.DB.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (emb *Embedded) Index(doc Document) error {
+	return emb.inTransaction(func(tx *sql.Tx) error {
+		return insertDocument(tx, doc, doc.Content)
+	})
+}
+
+func (emb *Embedded) Update(doc Document) error {
+	return emb.inTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`UPDATE Document SET url=?, domain=?, title=?, description=?, timestamp=?, is_read=?
+			WHERE id=? AND owner=?`,
+			doc.URL, doc.Domain, doc.Title, doc.Description, doc.Timestamp, doc.Read, doc.ID, doc.Owner)
+		if err != nil {
+			return err
+		} else if affected, _ := result.RowsAffected(); affected == 0 {
+			return insertDocument(tx, doc, doc.Content)
+		}
+
+		err = setDocumentTags(tx, doc)
+		if err != nil {
+			return err
+		}
+		if len(doc.Content) > 0 {
+			_, err = tx.Exec("UPDATE DocumentText SET title=?, description=?, url=?, content=? WHERE docid=?",
+				doc.Title, doc.Description, doc.URL, doc.Content, doc.ID)
+		} else {
+			_, err = tx.Exec("UPDATE DocumentText SET title=?, description=?, url=? WHERE docid=?",
+				doc.Title, doc.Description, doc.URL, doc.ID)
+		}
+		return err
+	})
+}
+
+func (emb *Embedded) Delete(owner, id int) error {
+	return emb.inTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM Document WHERE id=? AND owner=?", id, owner)
+		if err != nil {
+			return err
+		} else if affected, _ := result.RowsAffected(); affected == 0 {
+			return nil
+		}
+		_, err = tx.Exec("DELETE FROM DocumentText WHERE docid=?", id)
+		return err
+	})
+}
+
+// ftsQuery converts free text into an FTS4 query that matches documents containing any of the words as a prefix.
+func ftsQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		// FTS operators (OR, NOT, NEAR) are only recognized in uppercase.
+		words[i] = strings.ToLower(word) + "*"
+	}
+	return strings.Join(words, " OR ")
+}
+
+// ftsPhrase converts text into an FTS4 phrase query. Quotes can't be escaped in FTS queries, so they're removed.
+func ftsPhrase(text string) string {
+	return `"` + strings.Replace(text, `"`, "", -1) + `"`
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// embeddedOrders contains the ORDER BY clauses for each sort option. Relevance is only used if there's a text query.
+var embeddedOrders = map[Sort]string{
+	SortRelevance: "rank(matchinfo(DocumentText, 'pcx')) DESC, Document.timestamp DESC, Document.id DESC",
+	SortNewest:    "Document.timestamp DESC, Document.id DESC",
+	SortOldest:    "Document.timestamp ASC, Document.id ASC",
+	SortTitle:     "Document.title COLLATE NOCASE ASC, Document.id DESC",
+	SortDomain:    "Document.domain ASC, Document.timestamp DESC, Document.id DESC",
+}
+
+func (emb *Embedded) Query(q Query) (results Results, err error) {
+	match := ftsQuery(q.Text)
+	phrases := q.Phrases
+	if len(match) == 0 && len(phrases) > 0 {
+		// Use the first phrase as the main match so that it can be ranked and highlighted.
+		match, phrases = ftsPhrase(phrases[0]), phrases[1:]
+	}
+	sort := q.Sort
+	if _, ok := embeddedOrders[sort]; !ok || (sort == SortRelevance && len(match) == 0) {
+		sort = SortNewest
+	}
+
+	from := "Document"
+	conditions := []string{"Document.owner=?"}
+	args := []interface{}{q.Owner}
+	if len(match) > 0 {
+		from = "DocumentText JOIN Document ON Document.id=DocumentText.docid"
+		conditions = append(conditions, "DocumentText MATCH ?")
+		args = append(args, match)
+	}
+	// Phrases and excluded text use subqueries, as a table can only be matched once per query.
+	for _, phrase := range phrases {
+		conditions = append(conditions, "Document.id IN (SELECT docid FROM DocumentText WHERE DocumentText MATCH ?)")
+		args = append(args, ftsPhrase(phrase))
+	}
+	for _, text := range q.ExcludedText {
+		conditions = append(conditions,
+			"Document.id NOT IN (SELECT docid FROM DocumentText WHERE DocumentText MATCH ?)")
+		args = append(args, ftsPhrase(text))
+	}
+	for _, title := range q.Title {
+		conditions = append(conditions, "Document.title LIKE ? ESCAPE '!'")
+		args = append(args, "%"+likeEscaper.Replace(title)+"%")
+	}
+	if len(q.Tags) > 0 {
+		if q.ExclusiveTags {
+			for _, tag := range q.Tags {
+				conditions = append(conditions,
+					"EXISTS (SELECT 1 FROM DocumentTag WHERE document=Document.id AND tag=?)")
+				args = append(args, tag)
+			}
+		} else {
+			conditions = append(conditions, fmt.Sprintf(
+				"Document.id IN (SELECT document FROM DocumentTag WHERE tag IN (%s))", placeholders(len(q.Tags))))
+			args = append(args, stringToInterfaceSlice(q.Tags)...)
+		}
+	}
+	for _, tag := range q.RequiredTags {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM DocumentTag WHERE document=Document.id AND tag=?)")
+		args = append(args, tag)
+	}
+	for _, tag := range q.ExcludedTags {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM DocumentTag WHERE document=Document.id AND tag=?)")
+		args = append(args, tag)
+	}
+	if len(q.Domains) > 0 {
+		conditions = append(conditions, fmt.Sprintf("Document.domain IN (%s)", placeholders(len(q.Domains))))
+		args = append(args, stringToInterfaceSlice(q.Domains)...)
+	}
+	if len(q.ExcludedDomains) > 0 {
+		conditions = append(conditions,
+			fmt.Sprintf("Document.domain NOT IN (%s)", placeholders(len(q.ExcludedDomains))))
+		args = append(args, stringToInterfaceSlice(q.ExcludedDomains)...)
+	}
+	if q.Read != nil {
+		conditions = append(conditions, "Document.is_read=?")
+		args = append(args, *q.Read)
+	}
+	if q.Since != 0 {
+		conditions = append(conditions, "Document.timestamp>=?")
+		args = append(args, q.Since)
+	}
+	if q.Until != 0 {
+		conditions = append(conditions, "Document.timestamp<=?")
+		args = append(args, q.Until)
+	}
+
+	where := strings.Join(conditions, " AND ")
+	err = emb.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", from, where), args...).
+		Scan(&results.TotalHits)
+	if err != nil {
+		return
+	}
+	if q.FacetSize > 0 {
+		results.Facets, err = emb.facets(from, where, args, q.FacetSize)
+		if err != nil {
+			return
+		}
+	}
+
+	limit := ""
+	if q.Size > 0 {
+		limit = " LIMIT ? OFFSET ?"
+		args = append(args, q.Size, q.From)
+	} else if q.From > 0 {
+		limit = " LIMIT -1 OFFSET ?"
+		args = append(args, q.From)
+	}
+	// Snippets can only be created from the main full-text match, so there's nothing to highlight without one.
+	highlight := q.Highlight && len(match) > 0
+	snippets := "'', '', ''"
+	if highlight {
+		snippets = fmt.Sprintf(`snippet(DocumentText, '%[1]s', '%[2]s', '', 0, 64),
+			snippet(DocumentText, '%[1]s', '%[2]s', '…', 1, 24),
+			snippet(DocumentText, '%[1]s', '%[2]s', '…', 3, 24)`, highlightStart, highlightEnd)
+	}
+	rows, err := emb.DB.Query(fmt.Sprintf(`SELECT Document.id, Document.owner, Document.url, Document.domain,
+			Document.title, Document.description, Document.timestamp, Document.is_read,
+			(SELECT IFNULL(GROUP_CONCAT(tag), '') FROM DocumentTag WHERE document=Document.id), %s
+		FROM %s WHERE %s ORDER BY %s%s`, snippets, from, where, embeddedOrders[sort], limit), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var doc Document
+		var tags, titleSnippet, descriptionSnippet, contentSnippet string
+		err = rows.Scan(&doc.ID, &doc.Owner, &doc.URL, &doc.Domain, &doc.Title, &doc.Description, &doc.Timestamp,
+			&doc.Read, &tags, &titleSnippet, &descriptionSnippet, &contentSnippet)
+		if err != nil {
+			return
+		}
+		if highlight {
+			doc.addHighlight("title", titleSnippet)
+			doc.addHighlight("description", descriptionSnippet)
+			doc.addHighlight("content", contentSnippet)
+		}
+		if len(tags) > 0 {
+			doc.Tags = strings.Split(tags, ",")
+		}
+		results.Documents = append(results.Documents, doc)
+	}
+	err = rows.Err()
+	return
+}
+
+// facets counts the documents that match the given WHERE clause per tag, domain and month.
+func (emb *Embedded) facets(from, where string, args []interface{}, size int) (*Facets, error) {
+	var facets Facets
+	var err error
+	facets.Tags, err = emb.facetCounts(fmt.Sprintf(`SELECT tag, COUNT(*) FROM DocumentTag
+		WHERE document IN (SELECT Document.id FROM %s WHERE %s)
+		GROUP BY tag ORDER BY COUNT(*) DESC, tag LIMIT ?`, from, where), append(args, size)...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Domains, err = emb.facetCounts(fmt.Sprintf(`SELECT Document.domain, COUNT(*) FROM %s WHERE %s
+		GROUP BY Document.domain ORDER BY COUNT(*) DESC, Document.domain LIMIT ?`, from, where), append(args, size)...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Months, err = emb.facetCounts(fmt.Sprintf(`SELECT strftime('%%Y-%%m', Document.timestamp, 'unixepoch') AS month,
+		COUNT(*) FROM %s WHERE %s GROUP BY month ORDER BY month`, from, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	return &facets, nil
+}
+
+func (emb *Embedded) facetCounts(query string, args ...interface{}) ([]FacetCount, error) {
+	rows, err := emb.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := []FacetCount{}
+	for rows.Next() {
+		var count FacetCount
+		err = rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// Reindex replaces the documents in a single transaction, so searches see either the old or the new documents.
+func (emb *Embedded) Reindex(owner int, docs <-chan Document) error {
+	defer drain(docs)
+	return emb.inTransaction(func(tx *sql.Tx) error {
+		seen := make(map[int]bool)
+		for doc := range docs {
+			content := doc.Content
+			if len(content) == 0 {
+				err := tx.QueryRow("SELECT content FROM DocumentText WHERE docid=?", doc.ID).Scan(&content)
+				if err != nil && err != sql.ErrNoRows {
+					return err
+				}
+			}
+			err := insertDocument(tx, doc, content)
+			if err != nil {
+				return err
+			}
+			seen[doc.ID] = true
+		}
+		return deleteUnseen(tx, owner, seen)
+	})
+}
+
+// deleteUnseen deletes the documents of the given owner (or all documents if owner is zero) that are not in the seen
+// set.
+func deleteUnseen(tx *sql.Tx, owner int, seen map[int]bool) error {
+	query := "SELECT id FROM Document"
+	var args []interface{}
+	if owner != 0 {
+		query += " WHERE owner=?"
+		args = append(args, owner)
+	}
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var unseen []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if !seen[id] {
+			unseen = append(unseen, id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range unseen {
+		_, err = tx.Exec("DELETE FROM Document WHERE id=?", id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM DocumentText WHERE docid=?", id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (emb *Embedded) Close() error {
+	return emb.DB.Close()
+}
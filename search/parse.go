@@ -0,0 +1,199 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseError is returned by ParseQuery when the query is malformed.
+type ParseError struct {
+	// Position is the byte offset in the query where the error was found.
+	Position int
+	Message  string
+}
+
+func (err ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", err.Message, err.Position)
+}
+
+// queryTerm is a single whitespace-separated part of a query.
+type queryTerm struct {
+	position      int
+	valuePosition int
+	negated       bool
+	field         string
+	value         string
+	quoted        bool
+}
+
+// ParseQuery parses a search query. Free text goes into Text and Phrases, and field operators fill the filters:
+//
+//	tag:go -tag:old        links tagged with go and not tagged with old
+//	site:github.com        links in the domain (-site: excludes the domain)
+//	title:"error handling" links whose title contains the text
+//	before:2018-01-01      links saved before the date (after: is inclusive)
+//	is:read, is:unread     links that have or have not been marked as read
+//
+// Words with a colon that isn't a known operator, such as URLs, are treated as free text.
+func ParseQuery(str string) (query Query, err error) {
+	terms, err := splitQuery(str)
+	if err != nil {
+		return
+	}
+
+	var words []string
+	for _, term := range terms {
+		empty := len(strings.TrimSpace(term.value)) == 0
+		if len(term.field) > 0 && empty {
+			return query, ParseError{term.position, fmt.Sprintf("Missing value for %s:", term.field)}
+		}
+		switch term.field {
+		case "":
+			if empty {
+				// Empty quotes don't match anything, so they're ignored.
+				continue
+			}
+			if term.negated {
+				query.ExcludedText = append(query.ExcludedText, term.value)
+			} else if term.quoted {
+				query.Phrases = append(query.Phrases, term.value)
+			} else {
+				words = append(words, term.value)
+			}
+		case "tag":
+			// Tag names are always stored in lowercase.
+			tag := strings.ToLower(term.value)
+			if term.negated {
+				query.ExcludedTags = append(query.ExcludedTags, tag)
+			} else {
+				query.RequiredTags = append(query.RequiredTags, tag)
+			}
+		case "site", "domain":
+			if term.negated {
+				query.ExcludedDomains = append(query.ExcludedDomains, strings.ToLower(term.value))
+			} else {
+				query.Domains = append(query.Domains, strings.ToLower(term.value))
+			}
+		case "title":
+			if term.negated {
+				return query, ParseError{term.position, "title: can't be negated"}
+			}
+			query.Title = append(query.Title, term.value)
+		case "before", "after":
+			if term.negated {
+				return query, ParseError{term.position, fmt.Sprintf("%s: can't be negated", term.field)}
+			}
+			date, err := time.Parse("2006-01-02", term.value)
+			if err != nil {
+				return query, ParseError{term.valuePosition,
+					fmt.Sprintf("Invalid date %q, expected YYYY-MM-DD", term.value)}
+			}
+			if term.field == "before" {
+				query.Until = date.Unix() - 1
+			} else {
+				query.Since = date.Unix()
+			}
+		case "is":
+			read := term.value == "read"
+			if !read && term.value != "unread" {
+				return query, ParseError{term.valuePosition,
+					fmt.Sprintf("Unknown value %q for is:, expected read or unread", term.value)}
+			}
+			read = read != term.negated
+			query.Read = &read
+		}
+	}
+	query.Text = strings.Join(words, " ")
+	return
+}
+
+// queryFields contains the field operators that ParseQuery understands.
+var queryFields = map[string]bool{
+	"tag": true, "site": true, "domain": true, "title": true, "before": true, "after": true, "is": true,
+}
+
+// splitQuery splits a query into terms separated by whitespace. Quoted strings are kept together.
+func splitQuery(str string) (terms []queryTerm, err error) {
+	pos := 0
+	for {
+		for pos < len(str) {
+			char, size := utf8.DecodeRuneInString(str[pos:])
+			if !unicode.IsSpace(char) {
+				break
+			}
+			pos += size
+		}
+		if pos >= len(str) {
+			return
+		}
+
+		term := queryTerm{position: pos}
+		if str[pos] == '-' && pos+1 < len(str) && !unicode.IsSpace(rune(str[pos+1])) {
+			term.negated = true
+			pos++
+		}
+		if colon := fieldEnd(str[pos:]); colon > 0 && queryFields[strings.ToLower(str[pos:pos+colon])] {
+			term.field = strings.ToLower(str[pos : pos+colon])
+			pos += colon + 1
+		}
+
+		term.valuePosition = pos
+		if pos < len(str) && str[pos] == '"' {
+			end := strings.IndexByte(str[pos+1:], '"')
+			if end < 0 {
+				return nil, ParseError{pos, "Unterminated quote"}
+			}
+			term.value = str[pos+1 : pos+1+end]
+			term.quoted = true
+			pos += end + 2
+		} else {
+			start := pos
+			for pos < len(str) {
+				char, size := utf8.DecodeRuneInString(str[pos:])
+				if unicode.IsSpace(char) {
+					break
+				}
+				pos += size
+			}
+			term.value = str[start:pos]
+		}
+		terms = append(terms, term)
+	}
+}
+
+// fieldEnd returns the index of the colon after a field name at the start of the given string, or -1 if the string
+// doesn't start with a field name.
+func fieldEnd(str string) int {
+	for index, char := range str {
+		if char == ':' {
+			return index
+		} else if !unicode.IsLetter(char) {
+			return -1
+		}
+	}
+	return -1
+}
+
+// HasText returns true if the query contains free text, which requires a full-text search instead of just filtering.
+func (query Query) HasText() bool {
+	return len(strings.TrimSpace(query.Text)) > 0 || len(query.Phrases) > 0 || len(query.ExcludedText) > 0
+}
@@ -0,0 +1,112 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func boolPtr(value bool) *bool {
+	return &value
+}
+
+func TestParseQuery(t *testing.T) {
+	date := func(str string) int64 {
+		parsed, err := time.Parse("2006-01-02", str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Unix()
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  Query
+	}{
+		{"empty", "", Query{}},
+		{"words", "  go   error\thandling ", Query{Text: "go error handling"}},
+		{"phrase", `go "error handling"`, Query{Text: "go", Phrases: []string{"error handling"}}},
+		{"negated word", "go -java", Query{Text: "go", ExcludedText: []string{"java"}}},
+		{"negated phrase", `go -"hello world"`, Query{Text: "go", ExcludedText: []string{"hello world"}}},
+		{"lone dash", "a - b", Query{Text: "a - b"}},
+		{"empty phrase", `go "" -"  "`, Query{Text: "go"}},
+		{"tags", "tag:go tag:web -tag:old", Query{RequiredTags: []string{"go", "web"}, ExcludedTags: []string{"old"}}},
+		{"tag case", "TAG:Go -tag:OLD", Query{RequiredTags: []string{"go"}, ExcludedTags: []string{"old"}}},
+		{"quoted tag", `tag:"machine learning"`, Query{RequiredTags: []string{"machine learning"}}},
+		{"sites", "site:GitHub.com -domain:example.com",
+			Query{Domains: []string{"github.com"}, ExcludedDomains: []string{"example.com"}}},
+		{"title", `title:"error handling" title:go`, Query{Title: []string{"error handling", "go"}}},
+		{"dates", "after:2018-01-01 before:2018-02-01",
+			Query{Since: date("2018-01-01"), Until: date("2018-02-01") - 1}},
+		{"read", "is:read", Query{Read: boolPtr(true)}},
+		{"unread", "is:unread", Query{Read: boolPtr(false)}},
+		{"negated read", "-is:read", Query{Read: boolPtr(false)}},
+		{"unknown field", "https://example.com foo:bar", Query{Text: "https://example.com foo:bar"}},
+		{"mixed", `tag:go "error handling" -site:example.com is:unread panic`, Query{
+			Text:            "panic",
+			Phrases:         []string{"error handling"},
+			RequiredTags:    []string{"go"},
+			ExcludedDomains: []string{"example.com"},
+			Read:            boolPtr(false),
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) returned error: %v", test.query, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseQuery(%q) = %+v, want %+v", test.query, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		position int
+	}{
+		{`go "error handling`, 3},
+		{"go tag:", 3},
+		{`go tag:""`, 3},
+		{`-tag:" "`, 0},
+		{"-title:go", 0},
+		{"go -after:2018-01-01", 3},
+		{"before:yesterday", 7},
+		{"after:2018-13-01", 6},
+		{"is:maybe", 3},
+		{"go  is:", 4},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			_, err := ParseQuery(test.query)
+			parseErr, ok := err.(ParseError)
+			if !ok {
+				t.Fatalf("ParseQuery(%q) returned %v, want ParseError", test.query, err)
+			}
+			if parseErr.Position != test.position {
+				t.Errorf("ParseQuery(%q) returned error at %d, want %d: %v",
+					test.query, parseErr.Position, test.position, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package search contains the full-text search index drivers.
+package search
+
+// Index is a full-text search index for links.
+type Index interface {
+	// Index adds a document to the index, replacing any existing document with the same ID.
+	Index(doc Document) error
+	// Update updates an existing document, or adds it if it's not in the index yet. If the content of the given
+	// document is empty, the stored content and language are kept.
+	Update(doc Document) error
+	// Delete removes the document with the given ID and owner from the index.
+	Delete(owner, id int) error
+	// Query searches the documents of the owner in the given query.
+	Query(query Query) (Results, error)
+	// Reindex replaces the documents of the given owner with the documents read from the given channel. If the owner
+	// is zero, the whole index is replaced. The new documents become visible only after the channel is closed.
+	//
+	// Documents with an empty content field keep the page content and language currently stored in the index. Changes
+	// made to the index while the reindex is running may be lost, so they have to be redone afterwards.
+	Reindex(owner int, docs <-chan Document) error
+	// Close closes the connection to the index.
+	Close() error
+}
+
+// RelatedFinder is implemented by indexes that can find documents similar to an indexed document.
+type RelatedFinder interface {
+	// Related returns at most size documents of the owner that are similar to the document with the given ID, most
+	// similar first. The Score field of the returned documents is set.
+	Related(owner, id, size int) ([]Document, error)
+}
+
+// TitleSuggester is implemented by indexes that can find documents by title prefixes for search-as-you-type.
+type TitleSuggester interface {
+	// SuggestTitles returns at most size documents of the owner whose title contains words starting with each of the
+	// words in the given text. Only the ID, owner, URL, domain and title of the returned documents are set.
+	SuggestTitles(owner int, text string, size int) ([]Document, error)
+}
+
+// Document is a single link in the search index.
+type Document struct {
+	ID          int      `json:"id"`
+	Owner       int      `json:"owner"`
+	URL         string   `json:"url"`
+	Domain      string   `json:"domain"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Timestamp   int64    `json:"timestamp"`
+	Read        bool     `json:"read"`
+	// Content is the readable text of the page.
+	Content string `json:"content,omitempty"`
+	// Language is the ISO 639 code of the language of the content, or empty if it's not known.
+	Language string `json:"language,omitempty"`
+
+	// Highlights contains the fragments of the title, description and content that matched the query. It's only set
+	// in search results, and only if highlighting was requested.
+	Highlights map[string][]string `json:"-"`
+	// Score is the relevance of the document in the results of a query that scores documents.
+	Score float64 `json:"-"`
+}
+
+// Sort is the order in which search results are returned.
+type Sort string
+
+const (
+	SortRelevance Sort = "relevance"
+	SortNewest    Sort = "newest"
+	SortOldest    Sort = "oldest"
+	SortTitle     Sort = "title"
+	SortDomain    Sort = "domain"
+)
+
+// ParseSort parses a sort option. An empty string means relevance. If the sort is not known, ok is false.
+func ParseSort(str string) (sort Sort, ok bool) {
+	switch sort = Sort(str); sort {
+	case "":
+		return SortRelevance, true
+	case SortRelevance, SortNewest, SortOldest, SortTitle, SortDomain:
+		return sort, true
+	default:
+		return sort, false
+	}
+}
+
+// Query contains the parameters of a search.
+type Query struct {
+	Owner int
+	// Text is free text. Documents that match any of the words are returned.
+	Text string
+	// Phrases are quoted pieces of text that must all be in the document.
+	Phrases []string
+	// ExcludedText contains words or phrases that must not be in the document.
+	ExcludedText []string
+	// Title contains pieces of text that must all be in the title of the document.
+	Title []string
+
+	// Tags limits the results to documents that have any of the tags, or all of them if ExclusiveTags is true.
+	Tags          []string
+	ExclusiveTags bool
+	// RequiredTags limits the results to documents that have all of the tags, regardless of ExclusiveTags.
+	RequiredTags    []string
+	ExcludedTags    []string
+	Domains         []string
+	ExcludedDomains []string
+	// Read limits the results to documents that have (true) or have not (false) been read if it's not nil.
+	Read *bool
+	// Since and Until limit the results to documents with a timestamp in the range. Zero means no limit.
+	Since int64
+	Until int64
+
+	Sort Sort
+	// Highlight enables highlighting the matches in the results.
+	Highlight bool
+	// FacetSize is the number of top tags and domains to count in Results.Facets. Zero disables facets.
+	FacetSize int
+	// From is the number of results to skip.
+	From int
+	// Size is the maximum number of results to return. Zero means no limit.
+	Size int
+}
+
+// Results contains one page of search results.
+type Results struct {
+	Documents []Document
+	// TotalHits is the number of documents that matched the query, including the ones not on this page.
+	TotalHits int
+	// Facets contains the number of matching documents per tag, domain and month. It's only set if FacetSize is
+	// positive.
+	Facets *Facets
+}
+
+// FacetCount is the number of documents that have a specific value in a field.
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// Facets contains the distribution of the documents that matched a query.
+type Facets struct {
+	// Tags and Domains contain the most common values, most common first.
+	Tags    []FacetCount
+	Domains []FacetCount
+	// Months contains the number of documents per month (YYYY-MM) in chronological order. Empty months are omitted.
+	Months []FacetCount
+}
@@ -0,0 +1,96 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package search
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlToText extracts the visible text from a HTML document. It's used for the page content stored by versions that
+// indexed the whole HTML of pages.
+func htmlToText(body string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	skipDepth := 0
+	t := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch t.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(text.String())
+		case html.StartTagToken:
+			name, _ := t.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "template":
+				skipDepth++
+			}
+		case html.EndTagToken:
+			name, _ := t.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "template":
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				text.Write(t.Text())
+				text.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// The index drivers mark the matched terms in highlight fragments with these control characters, which can't appear in
+// the escaped output, and highlightFragment then replaces them with HTML tags.
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+var highlightReplacer = strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>")
+
+// highlightFragment converts a highlighted fragment from an index driver into HTML-safe text where the matches are
+// wrapped in <mark> tags. If the fragment doesn't contain any matches, an empty string is returned.
+func highlightFragment(fragment string) string {
+	if !strings.Contains(fragment, highlightStart) {
+		return ""
+	}
+	fragment = strings.Join(strings.Fields(fragment), " ")
+	return highlightReplacer.Replace(html.EscapeString(fragment))
+}
+
+// addHighlight adds a fragment to the highlights of the given field if it contains any matches.
+func (doc *Document) addHighlight(field, fragment string) {
+	fragment = highlightFragment(fragment)
+	if len(fragment) == 0 {
+		return
+	}
+	if doc.Highlights == nil {
+		doc.Highlights = make(map[string][]string)
+	}
+	doc.Highlights[field] = append(doc.Highlights[field], fragment)
+}
+
+// drain reads the given channel until it's closed, so that the sender doesn't get stuck if reindexing fails midway.
+func drain(docs <-chan Document) {
+	for range docs {
+	}
+}
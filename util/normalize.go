@@ -0,0 +1,96 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// trackingParams are query parameters that only identify where a visitor came from.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "gclsrc": true, "dclid": true, "msclkid": true, "yclid": true, "igshid": true,
+	"mc_cid": true, "mc_eid": true, "_ga": true, "_gl": true, "_hsenc": true, "_hsmi": true, "mkt_tok": true,
+	"ref_src": true, "ref_url": true, "spm": true, "oly_anon_id": true, "oly_enc_id": true, "vero_id": true,
+	"wickedid": true,
+}
+
+// isTrackingParam checks if the query parameter with the given name is a tracking parameter.
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// NormalizeURL converts the URL into a form where different ways of writing the same address are equal. It's used to
+// find duplicate links, so the result is not necessarily a working URL:
+//
+//   - http and https are treated as the same scheme
+//   - The host is lowercased, and default ports and trailing dots are removed
+//   - Trailing slashes and dot segments are removed from the path
+//   - Tracking parameters like utm_source and fbclid are removed, and the rest are sorted
+//   - The fragment is removed, unless it looks like the route of a single-page app (#! or #/)
+func NormalizeURL(original *url.URL) string {
+	if original == nil {
+		return ""
+	}
+	normalized := *original
+	normalized.Scheme = strings.ToLower(normalized.Scheme)
+	if normalized.Scheme == "http" {
+		normalized.Scheme = "https"
+	}
+
+	host := strings.ToLower(normalized.Hostname())
+	host = strings.TrimSuffix(host, ".")
+	port := normalized.Port()
+	if (original.Scheme == "http" && port == "80") || (original.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 addresses need the brackets back.
+		host = "[" + host + "]"
+	}
+	if len(port) > 0 {
+		host += ":" + port
+	}
+	normalized.Host = host
+
+	if len(normalized.Host) > 0 {
+		// Resolving the path against the URL itself removes dot segments from it.
+		resolved := original.ResolveReference(&url.URL{Path: original.Path, RawPath: original.RawPath})
+		normalized.Path = strings.TrimRight(resolved.Path, "/")
+		normalized.RawPath = strings.TrimRight(resolved.RawPath, "/")
+	}
+
+	query := normalized.Query()
+	for name := range query {
+		if isTrackingParam(name) {
+			delete(query, name)
+		}
+	}
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	// Encode sorts the parameters by name.
+	normalized.RawQuery = query.Encode()
+	normalized.ForceQuery = false
+
+	if !strings.HasPrefix(normalized.Fragment, "!") && !strings.HasPrefix(normalized.Fragment, "/") {
+		normalized.Fragment, normalized.RawFragment = "", ""
+	}
+	return normalized.String()
+}
@@ -0,0 +1,101 @@
+// lindeb - mau\Lu Link Database
+// Copyright (C) 2017 Maunium / Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"unchanged", "https://example.com/page", "https://example.com/page"},
+		{"http", "http://example.com/page", "https://example.com/page"},
+		{"scheme case", "HTTP://example.com/page", "https://example.com/page"},
+		{"host case", "https://Example.COM/Page", "https://example.com/Page"},
+		{"trailing dot", "https://example.com./page", "https://example.com/page"},
+		{"http default port", "http://example.com:80/page", "https://example.com/page"},
+		{"https default port", "https://example.com:443/page", "https://example.com/page"},
+		{"http port on https", "https://example.com:80/page", "https://example.com:80/page"},
+		{"custom port", "http://example.com:8080/page", "https://example.com:8080/page"},
+		{"ipv6", "http://[::1]:80/page", "https://[::1]/page"},
+		{"ipv6 port", "http://[::1]:8080/page", "https://[::1]:8080/page"},
+		{"trailing slash", "https://example.com/dir/", "https://example.com/dir"},
+		{"root", "https://example.com/", "https://example.com"},
+		{"dot segments", "https://example.com/a/./b/../c", "https://example.com/a/c"},
+		{"utm params", "https://example.com/page?utm_source=feed&utm_medium=rss", "https://example.com/page"},
+		{"tracking params", "https://example.com/page?id=5&fbclid=abc&gclid=def", "https://example.com/page?id=5"},
+		{"tracking param case", "https://example.com/page?UTM_Source=feed&id=5", "https://example.com/page?id=5"},
+		{"sorted params", "https://example.com/page?b=2&a=1&b=1", "https://example.com/page?a=1&b=1&b=2"},
+		{"empty query", "https://example.com/page?", "https://example.com/page"},
+		{"fragment", "https://example.com/page#section", "https://example.com/page"},
+		{"hashbang fragment", "https://example.com/#!/user/5", "https://example.com#!/user/5"},
+		{"route fragment", "https://example.com/app#/settings", "https://example.com/app#/settings"},
+		{"everything", "HTTP://WWW.Example.com:80/a/../b/?utm_campaign=x&q=go#top", "https://www.example.com/b?q=go"},
+		{"not hierarchical", "mailto:user@example.com", "mailto:user@example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := url.Parse(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := NormalizeURL(parsed)
+			if got != test.want {
+				t.Errorf("NormalizeURL(%q) = %q, want %q", test.url, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeURLEquivalent(t *testing.T) {
+	// Different ways of writing the same address must be detected as duplicates.
+	groups := [][]string{
+		{
+			"https://example.com/article",
+			"http://example.com/article/",
+			"https://EXAMPLE.com:443/article?utm_source=twitter",
+			"http://example.com:80/blog/../article#comments",
+		},
+		{
+			"https://example.com/search?q=go&page=2",
+			"https://example.com/search?page=2&q=go&fbclid=123",
+		},
+	}
+	for _, group := range groups {
+		var first string
+		for index, str := range group {
+			parsed, err := url.Parse(str)
+			if err != nil {
+				t.Fatal(err)
+			}
+			normalized := NormalizeURL(parsed)
+			if index == 0 {
+				first = normalized
+			} else if normalized != first {
+				t.Errorf("NormalizeURL(%q) = %q, want %q like %q", str, normalized, first, group[0])
+			}
+		}
+	}
+	if got := NormalizeURL(nil); got != "" {
+		t.Errorf("NormalizeURL(nil) = %q, want empty string", got)
+	}
+}